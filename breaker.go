@@ -0,0 +1,116 @@
+package fcm
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCircuitOpen returned by sends short-circuited by the circuit breaker
+	ErrCircuitOpen = errors.New("fcm: circuit breaker is open")
+)
+
+// circuitBreaker stops sends after threshold consecutive retryable failures
+// within window, until cooldown elapses. After the cooldown a single probe
+// send is let through: its success closes the circuit, its failure opens
+// it for another cooldown.
+type circuitBreaker struct {
+	mu           sync.Mutex
+	threshold    int
+	window       time.Duration
+	cooldown     time.Duration
+	failures     int
+	firstFailure time.Time
+	open         bool
+	openedAt     time.Time
+	probing      bool
+}
+
+// SetCircuitBreaker enables the circuit breaker: after threshold consecutive
+// retryable failures (network errors, 429, 5xx, retryable fcm errors) within
+// window, sends fail with ErrCircuitOpen until cooldown elapses.
+// A threshold <= 0 disables it, which is the default.
+func (this *FcmClient) SetCircuitBreaker(threshold int, window time.Duration, cooldown time.Duration) *FcmClient {
+
+	if threshold <= 0 {
+		this.breaker = nil
+	} else {
+		this.breaker = &circuitBreaker{
+			threshold: threshold,
+			window:    window,
+			cooldown:  cooldown,
+		}
+	}
+
+	return this
+}
+
+// allow returns ErrCircuitOpen when a send must not be attempted
+func (this *circuitBreaker) allow(now time.Time) error {
+	if this == nil {
+		return nil
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if !this.open {
+		return nil
+	}
+	if this.probing || now.Sub(this.openedAt) < this.cooldown {
+		return ErrCircuitOpen
+	}
+
+	this.probing = true
+
+	return nil
+}
+
+// record reports the outcome of an allowed send
+func (this *circuitBreaker) record(failed bool, now time.Time) {
+	if this == nil {
+		return
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if !failed {
+		this.failures = 0
+		this.open = false
+		this.probing = false
+		return
+	}
+
+	if this.probing {
+		this.probing = false
+		this.openedAt = now
+		return
+	}
+
+	if this.failures == 0 || now.Sub(this.firstFailure) > this.window {
+		this.failures = 0
+		this.firstFailure = now
+	}
+
+	this.failures++
+	if this.failures >= this.threshold {
+		this.failures = 0
+		this.open = true
+		this.openedAt = now
+	}
+}
+
+// release gives back a probe slot when the send outcome says nothing about
+// fcm health (e.g. the caller cancelled the context)
+func (this *circuitBreaker) release() {
+	if this == nil {
+		return
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.probing = false
+}
@@ -0,0 +1,110 @@
+package fcm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+
+	b := &circuitBreaker{threshold: 2, window: time.Minute, cooldown: time.Minute}
+	now := time.Now()
+
+	b.record(true, now)
+	if err := b.allow(now); err != nil {
+		t.Fatal("Circuit opened before the threshold")
+	}
+
+	b.record(true, now.Add(time.Second))
+	if err := b.allow(now.Add(2 * time.Second)); err != ErrCircuitOpen {
+		t.Fatal("Circuit not opened at the threshold")
+	}
+
+	// cooldown elapsed, a single probe goes through
+	later := now.Add(2 * time.Minute)
+	if err := b.allow(later); err != nil {
+		t.Fatal("Probe not allowed after the cooldown")
+	}
+	if err := b.allow(later); err != ErrCircuitOpen {
+		t.Fatal("Second probe allowed")
+	}
+
+	// failed probe opens the circuit again
+	b.record(true, later)
+	if err := b.allow(later.Add(time.Second)); err != ErrCircuitOpen {
+		t.Fatal("Circuit not reopened after a failed probe")
+	}
+
+	// successful probe closes it
+	later = later.Add(2 * time.Minute)
+	b.allow(later)
+	b.record(false, later)
+	if err := b.allow(later); err != nil {
+		t.Fatal("Circuit not closed after a successful probe")
+	}
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+
+	b := &circuitBreaker{threshold: 2, window: time.Second, cooldown: time.Minute}
+	now := time.Now()
+
+	b.record(true, now)
+	b.record(true, now.Add(time.Minute))
+	if err := b.allow(now.Add(time.Minute)); err != nil {
+		t.Error("Failures outside the window opened the circuit")
+	}
+}
+
+func TestCircuitBreakerSend(t *testing.T) {
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.SetCircuitBreaker(2, time.Minute, time.Minute)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	c.Send()
+	c.Send()
+
+	if _, err := c.Send(); err != ErrCircuitOpen {
+		t.Error("Expected ErrCircuitOpen, got ", err)
+	}
+	if requests != 2 {
+		t.Error("Open circuit still sent requests : ", requests)
+	}
+}
+
+func TestCircuitBreakerThrottled(t *testing.T) {
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.SetCircuitBreaker(3, time.Minute, time.Minute)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	for i := 0; i < 3; i++ {
+		c.Send()
+	}
+
+	if _, err := c.Send(); err != ErrCircuitOpen {
+		t.Error("Expected ErrCircuitOpen after repeated 429s, got ", err)
+	}
+	if requests != 3 {
+		t.Error("Open circuit still sent requests : ", requests)
+	}
+}
@@ -0,0 +1,160 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// max_registration_ids max number of tokens per legacy send request
+	max_registration_ids = 1000
+)
+
+// SendInChunks sends the message to its RegistrationIds in concurrent
+// requests of at most max_registration_ids tokens, and merges the responses
+// into one FcmResponseStatus whose Results follow the RegistrationIds order.
+// A chunk that fails as a whole gets one error result per token and the
+// first such error is returned along with the merged status.
+// With a result handler (see SetResultHandler), the results are streamed
+// instead of merged: handler gets the index of the token in RegistrationIds
+// and is called from one goroutine at a time, but the chunks interleave.
+func (this *FcmClient) SendInChunks(ctx context.Context) (*FcmResponseStatus, error) {
+	return this.sendChunks(ctx, &this.Message, this.resultHandler)
+}
+
+// SendInChunksDryRun works like SendInChunks with dry_run enabled for this
+// call only: the per-token results tell which tokens fcm would accept,
+// without delivering anything. The DryRun field of the message is left
+// untouched.
+func (this *FcmClient) SendInChunksDryRun(ctx context.Context) (*FcmResponseStatus, error) {
+
+	msg := this.Message
+	msg.DryRun = true
+
+	return this.sendChunks(ctx, &msg, this.resultHandler)
+}
+
+// sendChunks sends msg to its RegistrationIds in chunks, the results are
+// passed to handler instead of being merged when it is not nil
+func (this *FcmClient) sendChunks(ctx context.Context, msg *FcmMsg, handler func(i int, result map[string]string)) (*FcmResponseStatus, error) {
+
+	if len(msg.RegistrationIds) <= max_registration_ids {
+		return this.sendStreamed(ctx, msg, handler)
+	}
+
+	start := time.Now()
+	chunks := splitTokens(msg.RegistrationIds, max_registration_ids)
+	responses := make([]*FcmResponseStatus, len(chunks))
+	errs := make([]error, len(chunks))
+
+	var handlerMu sync.Mutex
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		chunkMsg := *msg
+		chunkMsg.RegistrationIds = chunk
+
+		var chunkHandler func(int, map[string]string)
+		if handler != nil {
+			offset := i * max_registration_ids
+			chunkHandler = func(j int, result map[string]string) {
+				handlerMu.Lock()
+				defer handlerMu.Unlock()
+				handler(offset+j, result)
+			}
+		}
+
+		wg.Add(1)
+		go func(i int, msg FcmMsg) {
+			defer wg.Done()
+			if chunkHandler == nil {
+				responses[i], errs[i] = this.sendOnce(ctx, &msg)
+				return
+			}
+
+			streamed := 0
+			responses[i], errs[i] = this.sendStreamed(ctx, &msg, func(j int, result map[string]string) {
+				streamed++
+				chunkHandler(j, result)
+			})
+			if failure := chunkFailure(responses[i], errs[i]); failure != "" {
+				for j := streamed; j < len(msg.RegistrationIds); j++ {
+					chunkHandler(j, map[string]string{error_key: failure})
+				}
+			}
+		}(i, chunkMsg)
+	}
+	wg.Wait()
+
+	status, err := mergeStatuses(chunks, responses, errs)
+	status.Latency = time.Since(start)
+	if handler != nil {
+		status.Results = nil
+		status.TokenResults = nil
+	}
+
+	return status, err
+}
+
+// chunkFailure returns the error of a chunk that failed as a whole, or ""
+func chunkFailure(resp *FcmResponseStatus, err error) string {
+	switch {
+	case errors.Is(err, ErrNon200):
+		return resp.Err
+	case err != nil:
+		return err.Error()
+	case !resp.Ok:
+		return http.StatusText(resp.StatusCode)
+	}
+
+	return ""
+}
+
+// mergeStatuses merges the chunks responses into one, the results of a failed
+// chunk are filled with its error so that they stay aligned with the tokens
+func mergeStatuses(chunks [][]string, responses []*FcmResponseStatus, errs []error) (*FcmResponseStatus, error) {
+
+	merged := &FcmResponseStatus{Ok: true, StatusCode: http.StatusOK}
+	var firstErr error
+
+	for i, resp := range responses {
+		if resp == nil {
+			resp = new(FcmResponseStatus)
+		}
+		merged.Attempts += resp.Attempts
+		if errs[i] != nil && firstErr == nil {
+			firstErr = errs[i]
+		}
+
+		failure := chunkFailure(resp, errs[i])
+
+		if failure != "" {
+			if merged.Ok {
+				merged.Ok = false
+				merged.StatusCode = resp.StatusCode
+				merged.RetryAfter = resp.RetryAfter
+				merged.Headers = resp.Headers
+			}
+			merged.Fail += len(chunks[i])
+			for _, token := range chunks[i] {
+				merged.Results = append(merged.Results, map[string]string{error_key: failure})
+				merged.TokenResults = append(merged.TokenResults, TokenResult{Token: token, Error: failure})
+			}
+			continue
+		}
+
+		if merged.MulticastId == 0 {
+			merged.MulticastId = resp.MulticastId
+		}
+		merged.Success += resp.Success
+		merged.Fail += resp.Fail
+		merged.Canonical_ids += resp.Canonical_ids
+		merged.streamedRetryable += resp.streamedRetryable
+		merged.Results = append(merged.Results, resp.Results...)
+		merged.TokenResults = append(merged.TokenResults, resp.TokenResults...)
+	}
+
+	return merged, firstErr
+}
@@ -0,0 +1,199 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendInChunksOrder(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+
+		// answer the first chunk last to shuffle the completion order
+		if msg.RegistrationIds[0] == "token0" {
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		resp := map[string]interface{}{"multicast_id": 1, "success": len(msg.RegistrationIds)}
+		results := make([]map[string]string, len(msg.RegistrationIds))
+		for i, token := range msg.RegistrationIds {
+			results[i] = map[string]string{"message_id": "id-" + token}
+		}
+		resp["results"] = results
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	tokens := make([]string, 2500)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token%d", i)
+	}
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmRegIdsMsg(tokens, map[string]string{"msg": "Hello World"})
+
+	res, err := c.SendInChunks(context.Background())
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if !res.Ok || res.Success != len(tokens) || res.Attempts != 3 {
+		t.Error("Wrong merged status : ", res.Ok, res.Success, res.Attempts)
+	}
+	if len(res.Results) != len(tokens) {
+		t.Fatal("Expected one result per token, got ", len(res.Results))
+	}
+	for i, token := range tokens {
+		if res.Results[i]["message_id"] != "id-"+token {
+			t.Fatal("Result out of order at ", i)
+		}
+	}
+	if len(c.Message.RegistrationIds) != len(tokens) {
+		t.Error("SendInChunks changed the message")
+	}
+}
+
+func TestMergeStatusesFailedChunk(t *testing.T) {
+
+	chunks := [][]string{{"a", "b"}, {"c"}, {"d"}}
+	responses := []*FcmResponseStatus{
+		{Ok: true, StatusCode: 200, Success: 1, Fail: 1, Results: []map[string]string{{"message_id": "1"}, {"error": "NotRegistered"}}, Attempts: 1},
+		{StatusCode: 503, RetryAfter: "10", Attempts: 1},
+		nil,
+	}
+	errs := []error{nil, nil, errors.New("connection reset")}
+
+	res, err := mergeStatuses(chunks, responses, errs)
+	if err != errs[2] {
+		t.Error("Expected the chunk error, got ", err)
+	}
+	if res.Ok || res.StatusCode != 503 || res.RetryAfter != "10" {
+		t.Error("Failed chunk status not reported : ", res.StatusCode, res.RetryAfter)
+	}
+	if res.Success != 1 || res.Fail != 3 || len(res.Results) != 4 {
+		t.Error("Wrong counts : ", res.Success, res.Fail, len(res.Results))
+	}
+	if res.Results[2][error_key] != "Service Unavailable" || res.Results[3][error_key] != "connection reset" {
+		t.Error("Failed chunk results not filled : ", res.Results)
+	}
+}
+
+func TestSendInChunksDryRun(t *testing.T) {
+
+	var mu sync.Mutex
+	dryRuns := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+
+		mu.Lock()
+		if msg.DryRun {
+			dryRuns++
+		}
+		mu.Unlock()
+
+		results := make([]map[string]string, len(msg.RegistrationIds))
+		for i, token := range msg.RegistrationIds {
+			results[i] = map[string]string{"message_id": "fake_message_id"}
+			if token == "stale" {
+				results[i] = map[string]string{"error": "NotRegistered"}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	defer srv.Close()
+
+	tokens := make([]string, 1500)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token%d", i)
+	}
+	tokens[1200] = "stale"
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmRegIdsMsg(tokens, map[string]string{"msg": "Hello World"})
+
+	res, err := c.SendInChunksDryRun(context.Background())
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if dryRuns != 2 {
+		t.Error("Expected 2 dry run chunks, got ", dryRuns)
+	}
+	if res.TokenResults[1200].Token != "stale" || res.TokenResults[1200].Error != "NotRegistered" {
+		t.Error("Wrong dry run result : ", res.TokenResults[1200])
+	}
+	if c.Message.DryRun {
+		t.Error("Dry run leaked into the message")
+	}
+}
+
+func TestSendInChunksResultHandler(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+
+		if msg.RegistrationIds[0] == "token2000" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		results := make([]map[string]string, len(msg.RegistrationIds))
+		for i, token := range msg.RegistrationIds {
+			results[i] = map[string]string{"message_id": "id-" + token}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": len(results), "results": results})
+	}))
+	defer srv.Close()
+
+	tokens := make([]string, 2500)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token%d", i)
+	}
+
+	streamed := map[int]map[string]string{}
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmRegIdsMsg(tokens, map[string]string{"msg": "Hello World"})
+	c.SetResultHandler(func(i int, result map[string]string) {
+		if _, seen := streamed[i]; seen {
+			t.Error("Result streamed twice for index ", i)
+		}
+		streamed[i] = result
+	})
+
+	res, err := c.SendInChunks(context.Background())
+	if !errors.Is(err, ErrNon200) {
+		t.Error("Expected the failed chunk error, got ", err)
+	}
+
+	if len(streamed) != len(tokens) {
+		t.Fatal("Expected one streamed result per token, got ", len(streamed))
+	}
+	for i, token := range tokens {
+		if i >= 2000 {
+			if streamed[i][error_key] != "Service Unavailable" {
+				t.Fatal("Wrong failed chunk result at ", i, streamed[i])
+			}
+			continue
+		}
+		if streamed[i]["message_id"] != "id-"+token {
+			t.Fatal("Streamed result not matched with its token at ", i)
+		}
+	}
+	if len(res.Results) != 0 || res.Success != 2000 || res.Fail != 500 {
+		t.Error("Wrong merged status : ", len(res.Results), res.Success, res.Fail)
+	}
+}
@@ -0,0 +1,105 @@
+package fcm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// default_send_each_workers default number of concurrent requests of SendEach
+	default_send_each_workers = 10
+)
+
+// WithSendEachConcurrency sets the max number of concurrent requests of
+// SendEach, 0 restores the default of 10
+func WithSendEachConcurrency(n int) SenderOption {
+	return func(c *FcmClient) error {
+		c.eachWorkers = n
+		return nil
+	}
+}
+
+// SendEach sends each message as is with one request per message, a bounded
+// number at a time (see WithSendEachConcurrency). It is meant for different
+// messages per user, a message for many tokens is better sent by
+// SendMulticast. The results are aligned with messages.
+func (this *Sender) SendEach(ctx context.Context, messages []*FcmMsg) (*MulticastResult, error) {
+
+	workers := this.client.eachWorkers
+	if workers <= 0 {
+		workers = default_send_each_workers
+	}
+
+	result := this.client.sendAll(ctx, messages, workers)
+	for i, msg := range messages {
+		result.Targets[i] = msg.target()
+	}
+
+	return result, ctx.Err()
+}
+
+// sendAll sends each of msgs in its own request, at most workers at a time,
+// the Targets of the result are left to the caller
+func (this *FcmClient) sendAll(ctx context.Context, msgs []*FcmMsg, workers int) *MulticastResult {
+
+	result := &MulticastResult{
+		Targets:   make([]string, len(msgs)),
+		Responses: make([]*FcmResponseStatus, len(msgs)),
+		Errors:    make([]error, len(msgs)),
+	}
+
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, msg := range msgs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, msg *FcmMsg) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result.Responses[i], result.Errors[i] = this.sendOnce(ctx, msg)
+		}(i, msg)
+	}
+	wg.Wait()
+
+	for i, resp := range result.Responses {
+		if result.Errors[i] == nil && resp.Error() == nil {
+			result.Success++
+		} else {
+			result.Fail++
+		}
+	}
+
+	return result
+}
+
+// SendAllConcurrent sends the message to each token in its own request, at
+// most workers at a time (10 when workers <= 0), and merges the responses
+// into one FcmResponseStatus whose Results follow the tokens order. The
+// message target is ignored and left untouched. A message for many tokens
+// is usually cheaper to send by SendInChunks, 1000 tokens per request.
+func (this *FcmClient) SendAllConcurrent(ctx context.Context, tokens []string, workers int) (*FcmResponseStatus, error) {
+
+	if workers <= 0 {
+		workers = default_send_each_workers
+	}
+
+	msgs := make([]*FcmMsg, len(tokens))
+	chunks := make([][]string, len(tokens))
+	for i, token := range tokens {
+		msg := this.Message
+		msg.To = token
+		msg.RegistrationIds = nil
+		msg.Condition = ""
+		msgs[i] = &msg
+		chunks[i] = []string{token}
+	}
+
+	start := time.Now()
+	result := this.sendAll(ctx, msgs, workers)
+
+	status, err := mergeStatuses(chunks, result.Responses, result.Errors)
+	status.Latency = time.Since(start)
+
+	return status, err
+}
@@ -0,0 +1,115 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSenderSendEach(t *testing.T) {
+
+	var inFlight, maxInFlight int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+		if msg.To == "stale" {
+			fmt.Fprint(w, `{"failure":1,"results":[{"error":"NotRegistered"}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"success":1,"results":[{"message_id":%q}]}`, msg.Data.(map[string]interface{})["body"])
+	}))
+	defer srv.Close()
+
+	s, err := NewSender("key", WithEndpoint(srv.URL), WithSendEachConcurrency(3))
+	if err != nil {
+		t.Fatal("NewSender Error : ", err)
+	}
+
+	messages := make([]*FcmMsg, 12)
+	for i := range messages {
+		messages[i] = &FcmMsg{To: fmt.Sprintf("token%d", i), Data: map[string]string{"body": fmt.Sprintf("hello %d", i)}}
+	}
+	messages[5].To = "stale"
+
+	res, err := s.SendEach(context.Background(), messages)
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if res.Success != 11 || res.Fail != 1 {
+		t.Error("Wrong counts : ", res.Success, res.Fail)
+	}
+	for i, resp := range res.Responses {
+		if i == 5 {
+			continue
+		}
+		if res.Targets[i] != messages[i].To || resp.Results[0]["message_id"] != fmt.Sprintf("hello %d", i) {
+			t.Error("Results not aligned with messages at ", i)
+		}
+	}
+	if res.Targets[5] != "stale" || res.Responses[5].TokenResults[0].Error != "NotRegistered" {
+		t.Error("Wrong failed result : ", res.Responses[5].Results)
+	}
+	if maxInFlight > 3 {
+		t.Error("Concurrency limit not enforced : ", maxInFlight)
+	}
+}
+
+func TestSendAllConcurrent(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+		if msg.To == "stale" {
+			fmt.Fprint(w, `{"failure":1,"results":[{"error":"NotRegistered"}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"success":1,"results":[{"message_id":%q}]}`, "id-"+msg.To)
+	}))
+	defer srv.Close()
+
+	tokens := make([]string, 50)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token%d", i)
+	}
+	tokens[20] = "stale"
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmMsgTo("/topics/ignored", map[string]string{"msg": "Hello World"})
+
+	res, err := c.SendAllConcurrent(context.Background(), tokens, 4)
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if res.Success != 49 || res.Fail != 1 || len(res.Results) != len(tokens) {
+		t.Error("Wrong merged status : ", res.Success, res.Fail, len(res.Results))
+	}
+	for i, token := range tokens {
+		if i != 20 && res.TokenResults[i].MessageID != "id-"+token {
+			t.Fatal("Result out of order at ", i)
+		}
+	}
+	if invalid := res.InvalidTokens(); len(invalid) != 1 || invalid[0] != "stale" {
+		t.Error("Wrong invalid tokens : ", invalid)
+	}
+	if c.Message.To != "/topics/ignored" {
+		t.Error("SendAllConcurrent changed the message")
+	}
+}
@@ -0,0 +1,129 @@
+package fcm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNon200 is matched (errors.Is) by the error returned for a non 200
+	// response, which is an *FcmError with the details
+	ErrNon200 = errors.New("fcm: non 200 response")
+
+	// ErrUnregistered the token is no longer valid (NotRegistered)
+	ErrUnregistered = errors.New("fcm: unregistered")
+	// ErrInvalidArgument the request or the token is malformed
+	ErrInvalidArgument = errors.New("fcm: invalid argument")
+	// ErrQuotaExceeded too many messages to a device, a topic or overall
+	ErrQuotaExceeded = errors.New("fcm: quota exceeded")
+	// ErrSenderIDMismatch the token belongs to another sender
+	ErrSenderIDMismatch = errors.New("fcm: sender id mismatch")
+	// ErrUnavailable fcm is temporarily unavailable
+	ErrUnavailable = errors.New("fcm: unavailable")
+	// ErrInternal fcm failed internally
+	ErrInternal = errors.New("fcm: internal error")
+
+	// errorCodeSentinels the sentinel errors matched by the fcm error codes
+	errorCodeSentinels = map[string]error{
+		"NotRegistered":             ErrUnregistered,
+		"MissingRegistration":       ErrInvalidArgument,
+		"InvalidRegistration":       ErrInvalidArgument,
+		"InvalidPackageName":        ErrInvalidArgument,
+		"MessageTooBig":             ErrInvalidArgument,
+		"InvalidDataKey":            ErrInvalidArgument,
+		"InvalidTtl":                ErrInvalidArgument,
+		"InvalidParameters":         ErrInvalidArgument,
+		"MismatchSenderId":          ErrSenderIDMismatch,
+		"DeviceMessageRateExceeded": ErrQuotaExceeded,
+		"TopicsMessageRateExceeded": ErrQuotaExceeded,
+		"Unavailable":               ErrUnavailable,
+		"InternalServerError":       ErrInternal,
+	}
+
+	// statusCodeSentinels the sentinel errors matched by the http status
+	// codes, when the error code is not a known one
+	statusCodeSentinels = map[int]error{
+		http.StatusBadRequest:          ErrInvalidArgument,
+		http.StatusTooManyRequests:     ErrQuotaExceeded,
+		http.StatusInternalServerError: ErrInternal,
+		http.StatusServiceUnavailable:  ErrUnavailable,
+	}
+)
+
+// FcmError error reported by fcm for a send: Code is the http status code,
+// Status its text and ErrorCode the fcm error (e.g. NotRegistered)
+type FcmError struct {
+	Code      int
+	Status    string
+	ErrorCode string
+}
+
+// Error implements error
+func (this *FcmError) Error() string {
+	if this.ErrorCode == "" {
+		return fmt.Sprintf("fcm: %d %s", this.Code, this.Status)
+	}
+
+	return fmt.Sprintf("fcm: %s (%d %s)", this.ErrorCode, this.Code, this.Status)
+}
+
+// Is makes errors.Is match ErrNon200 for a non 200 response, and the
+// sentinel error of the fcm error code (e.g. ErrUnregistered for
+// NotRegistered) or else of the http status code
+func (this *FcmError) Is(target error) bool {
+	if target == ErrNon200 {
+		return this.Code != http.StatusOK
+	}
+
+	if sentinel, ok := errorCodeSentinels[this.ErrorCode]; ok {
+		return target == sentinel
+	}
+
+	return target != nil && target == statusCodeSentinels[this.Code]
+}
+
+// Error returns the error reported by fcm as a *FcmError, or nil: for a non
+// 200 response, a topic error, or the error of a single token send.
+// Errors of a multicast are per token, see TokenResults.
+func (this *FcmResponseStatus) Error() error {
+
+	fcmErr := &FcmError{
+		Code:   this.StatusCode,
+		Status: http.StatusText(this.StatusCode),
+	}
+
+	switch {
+	case this.StatusCode != http.StatusOK:
+		fcmErr.ErrorCode = this.Err
+	case this.Err != "":
+		fcmErr.ErrorCode = this.Err
+	case len(this.Results) == 1 && this.Results[0][error_key] != "":
+		fcmErr.ErrorCode = this.Results[0][error_key]
+	default:
+		return nil
+	}
+
+	return fcmErr
+}
+
+// errorFromBody extracts the error of a non 200 response body: the "error"
+// field of a json body, a one line text body, or the status text
+func errorFromBody(statusCode int, body []byte) string {
+
+	var jsonBody struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &jsonBody) == nil && jsonBody.Error != "" {
+		return jsonBody.Error
+	}
+
+	text := strings.TrimSpace(string(body))
+	if text != "" && !strings.ContainsAny(text, "<\n") {
+		return text
+	}
+
+	return http.StatusText(statusCode)
+}
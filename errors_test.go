@@ -0,0 +1,109 @@
+package fcm
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseStatusError(t *testing.T) {
+
+	ok := &FcmResponseStatus{StatusCode: 200, Results: []map[string]string{{"message_id": "1"}}}
+	if ok.Error() != nil {
+		t.Error("Unexpected error : ", ok.Error())
+	}
+
+	single := &FcmResponseStatus{StatusCode: 200, Results: []map[string]string{{"error": "NotRegistered"}}}
+	fcmErr, isFcmErr := single.Error().(*FcmError)
+	if !isFcmErr || fcmErr.ErrorCode != "NotRegistered" || fcmErr.Code != 200 {
+		t.Error("Wrong single token error : ", single.Error())
+	}
+
+	topic := &FcmResponseStatus{StatusCode: 200, Err: "TopicsMessageRateExceeded"}
+	if errors.Is(topic.Error(), ErrNon200) {
+		t.Error("A 200 response matched ErrNon200")
+	}
+	if fcmErr, _ := topic.Error().(*FcmError); fcmErr == nil || fcmErr.ErrorCode != "TopicsMessageRateExceeded" {
+		t.Error("Wrong topic error : ", topic.Error())
+	}
+
+	unauthorized := &FcmResponseStatus{StatusCode: 401}
+	if err := unauthorized.Error(); err == nil || err.Error() != "fcm: 401 Unauthorized" {
+		t.Error("Wrong http error : ", err)
+	}
+
+	multicast := &FcmResponseStatus{StatusCode: 200, Results: []map[string]string{{"message_id": "1"}, {"error": "NotRegistered"}}}
+	if multicast.Error() != nil {
+		t.Error("Multicast errors are per token : ", multicast.Error())
+	}
+}
+
+func TestNon200Error(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "key=key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("<HTML><TITLE>Unauthorized</TITLE></HTML>"))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("JSON_PARSING_ERROR: Unexpected character"))
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	res, err := c.Send()
+	if !errors.Is(err, ErrNon200) {
+		t.Fatal("Expected ErrNon200, got ", err)
+	}
+	if fcmErr, ok := err.(*FcmError); !ok || fcmErr.Code != 400 {
+		t.Error("Expected a 400 *FcmError : ", err)
+	}
+	if res.Ok || res.Err != "JSON_PARSING_ERROR: Unexpected character" {
+		t.Error("Wrong status : ", res.Ok, res.Err)
+	}
+
+	c.ApiKey = "bad"
+	res, err = c.Send()
+	if !errors.Is(err, ErrNon200) || res.Err != "Unauthorized" {
+		t.Error("Wrong unauthorized error : ", err, res.Err)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+
+	cases := []struct {
+		err      error
+		sentinel error
+	}{
+		{&FcmError{Code: 200, ErrorCode: "NotRegistered"}, ErrUnregistered},
+		{&FcmError{Code: 200, ErrorCode: "InvalidRegistration"}, ErrInvalidArgument},
+		{&FcmError{Code: 200, ErrorCode: "MismatchSenderId"}, ErrSenderIDMismatch},
+		{&FcmError{Code: 200, ErrorCode: "TopicsMessageRateExceeded"}, ErrQuotaExceeded},
+		{&FcmError{Code: 200, ErrorCode: "Unavailable"}, ErrUnavailable},
+		{&FcmError{Code: 200, ErrorCode: "InternalServerError"}, ErrInternal},
+		{&FcmError{Code: 503, ErrorCode: "Service Unavailable"}, ErrUnavailable},
+		{&FcmError{Code: 429}, ErrQuotaExceeded},
+		{TokenResult{Error: "NotRegistered"}.Err(), ErrUnregistered},
+	}
+
+	for _, c := range cases {
+		if !errors.Is(c.err, c.sentinel) {
+			t.Error("Sentinel not matched : ", c.err, c.sentinel)
+		}
+	}
+
+	if errors.Is(&FcmError{Code: 200, ErrorCode: "NotRegistered"}, ErrInvalidArgument) {
+		t.Error("Wrong sentinel matched")
+	}
+	if errors.Is(&FcmError{Code: 401, ErrorCode: "Unauthorized"}, ErrInternal) {
+		t.Error("Unmapped status matched a sentinel")
+	}
+	if (TokenResult{MessageID: "1"}).Err() != nil {
+		t.Error("Unexpected error for a delivered token")
+	}
+}
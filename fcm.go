@@ -2,16 +2,27 @@ package fcm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	// fcm_server_url fcm server url
-	fcm_server_url = "https://fcm.googleapis.com/fcm/send"
+	// fcm_endpoint default fcm server base url
+	fcm_endpoint = "https://fcm.googleapis.com"
+	// fcm_send_path legacy send path, relative to the endpoint
+	fcm_send_path = "/fcm/send"
 	// MAX_TTL the default ttl for a notification
 	MAX_TTL = 2419200
 	// Priority_HIGH notification priority
@@ -22,6 +33,12 @@ const (
 	retry_after_header = "Retry-After"
 	// error_key readable error caching !
 	error_key = "error"
+	// lib_name name reported in the default user agent
+	lib_name = "go-fcm"
+	// max_condition_topics max number of topics in a condition
+	max_condition_topics = 5
+	// default_timeout time budget of a send when none is set
+	default_timeout = 30 * time.Second
 )
 
 var (
@@ -31,14 +48,48 @@ var (
 		"InternalServerError": true,
 	}
 
-	// fcmServerUrl for testing purposes
-	fcmServerUrl = fcm_server_url
+	// invalidTokenErrors errors meaning a registration token will never be valid
+	invalidTokenErrors = map[string]bool{
+		"InvalidRegistration": true,
+		"NotRegistered":       true,
+	}
+
+	// conditionTopicRegexp matches a topic reference in a condition
+	conditionTopicRegexp = regexp.MustCompile(`'[^']*'\s+in\s+topics`)
+
+	// analyticsLabelRegexp valid analytics labels, as documented by fcm
+	analyticsLabelRegexp = regexp.MustCompile(`^[a-zA-Z0-9-_.~%]{1,50}$`)
+
+	// defaultUserAgent user agent sent when none is set
+	defaultUserAgent = buildUserAgent()
 )
 
-// FcmClient stores the key and the Message (FcmMsg)
+// FcmClient stores the key and the Message (FcmMsg).
+// The fluent setters mutate the shared Message and are not goroutine-safe,
+// to send concurrently build the message once with BuildMessage and pass
+// it to SendMessage, or use a Sender.
 type FcmClient struct {
-	ApiKey  string
-	Message FcmMsg
+	ApiKey      string
+	Message     FcmMsg
+	UserAgent   string
+	endpoint    string
+	iidEndpoint string
+	timeout     time.Duration
+	eachWorkers int
+	logger      Logger
+	client      *http.Client
+	clientMu    sync.Mutex
+	breaker     *circuitBreaker
+	slots       chan struct{}
+
+	resultHandler func(i int, result map[string]string)
+	bodyLogLength int
+	maxBodyLength int
+
+	// retryBaseDelay and retryMaxDelay bound the SendWithRetry backoff,
+	// 0 means the default
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
 }
 
 // FcmMsg represents fcm request message
@@ -50,42 +101,64 @@ type FcmMsg struct {
 	Priority              string              `json:"priority,omitempty"`
 	Notification          NotificationPayload `json:"notification,omitempty"`
 	ContentAvailable      bool                `json:"content_available,omitempty"`
+	MutableContent        bool                `json:"mutable_content,omitempty"`
 	DelayWhileIdle        bool                `json:"delay_while_idle,omitempty"`
 	TimeToLive            int                 `json:"time_to_live,omitempty"`
 	RestrictedPackageName string              `json:"restricted_package_name,omitempty"`
+	RestrictedPackageList []string            `json:"restricted_package_name_list,omitempty"`
 	DryRun                bool                `json:"dry_run,omitempty"`
 	Condition             string              `json:"condition,omitempty"`
+	FcmOptions            *FcmOptions         `json:"fcm_options,omitempty"`
 }
 
-// FcmMsg represents fcm response message - (tokens and topics)
+// FcmOptions fcm features options of a message
+type FcmOptions struct {
+	AnalyticsLabel string `json:"analytics_label,omitempty"`
+}
+
+// FcmResponseStatus represents fcm response message - (tokens and topics),
+// TokenResults holds the Results typed and matched with their tokens,
+// Headers and RawBody hold the http response headers and body (the body is
+// not kept when a result handler streams it), Latency and Attempts report how
+// long the send took and how many requests it made
 type FcmResponseStatus struct {
-	Ok            bool
-	StatusCode    int
+	Ok            bool                `json:"ok"`
+	StatusCode    int                 `json:"status_code"`
 	MulticastId   int64               `json:"multicast_id"`
 	Success       int                 `json:"success"`
 	Fail          int                 `json:"failure"`
 	Canonical_ids int                 `json:"canonical_ids"`
 	Results       []map[string]string `json:"results,omitempty"`
+	TokenResults  []TokenResult       `json:"token_results,omitempty"`
 	MsgId         int64               `json:"message_id,omitempty"`
 	Err           string              `json:"error,omitempty"`
-	RetryAfter    string
+	RetryAfter    string              `json:"retry_after,omitempty"`
+	Headers       http.Header         `json:"headers,omitempty"`
+	RawBody       []byte              `json:"-"`
+	Latency       time.Duration       `json:"latency"`
+	Attempts      int                 `json:"attempts"`
+
+	// streamedRetryable number of retryable results passed to a result
+	// handler, which are not kept in Results
+	streamedRetryable int
 }
 
 // NotificationPayload notification message payload
 type NotificationPayload struct {
-	Title            string `json:"title,omitempty"`
-	Body             string `json:"body,omitempty"`
-	Icon             string `json:"icon,omitempty"`
-	Sound            string `json:"sound,omitempty"`
-	Badge            string `json:"badge,omitempty"`
-	Tag              string `json:"tag,omitempty"`
-	Color            string `json:"color,omitempty"`
-	ClickAction      string `json:"click_action,omitempty"`
-	BodyLocKey       string `json:"body_loc_key,omitempty"`
-	BodyLocArgs      string `json:"body_loc_args,omitempty"`
-	TitleLocKey      string `json:"title_loc_key,omitempty"`
-	TitleLocArgs     string `json:"title_loc_args,omitempty"`
-	AndroidChannelID string `json:"android_channel_id,omitempty"`
+	Title            string   `json:"title,omitempty"`
+	Body             string   `json:"body,omitempty"`
+	Icon             string   `json:"icon,omitempty"`
+	Image            string   `json:"image,omitempty"`
+	Sound            string   `json:"sound,omitempty"`
+	Badge            string   `json:"badge,omitempty"`
+	Tag              string   `json:"tag,omitempty"`
+	Color            string   `json:"color,omitempty"`
+	ClickAction      string   `json:"click_action,omitempty"`
+	BodyLocKey       string   `json:"body_loc_key,omitempty"`
+	BodyLocArgs      []string `json:"body_loc_args,omitempty"`
+	TitleLocKey      string   `json:"title_loc_key,omitempty"`
+	TitleLocArgs     []string `json:"title_loc_args,omitempty"`
+	AndroidChannelID string   `json:"android_channel_id,omitempty"`
 }
 
 // NewFcmClient init and create fcm client
@@ -112,6 +185,38 @@ func (this *FcmClient) NewFcmMsgTo(to string, body interface{}) *FcmClient {
 	return this
 }
 
+// ResetMessage clears the message so the client can be reused for a
+// different send, the api key and the transport settings are kept
+func (this *FcmClient) ResetMessage() *FcmClient {
+
+	this.Message = FcmMsg{}
+
+	return this
+}
+
+// NewDataMessage sets up a data only message to the targeted token/topic,
+// for background sync: no notification, high priority and
+// content_available so that iOS apps are woken up
+func (this *FcmClient) NewDataMessage(to string, data map[string]string) *FcmClient {
+
+	this.Message.To = to
+	this.Message.Data = data
+	this.Message.Notification = NotificationPayload{}
+	this.Message.Priority = Priority_HIGH
+	this.Message.ContentAvailable = true
+
+	return this
+}
+
+// SetTopic targets a topic, given with or without the "/topics/" prefix.
+// The legacy api takes topics in "to", so it replaces any token target.
+func (this *FcmClient) SetTopic(topic string) *FcmClient {
+
+	this.Message.To = topicTarget(topic)
+
+	return this
+}
+
 // SetMsgData sets data payload
 func (this *FcmClient) SetMsgData(body interface{}) *FcmClient {
 
@@ -121,6 +226,25 @@ func (this *FcmClient) SetMsgData(body interface{}) *FcmClient {
 
 }
 
+// SetStruct sets the data payload from a struct, honoring its json tags,
+// the struct must encode to a json object
+func (this *FcmClient) SetStruct(body interface{}) error {
+
+	jsonByte, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(jsonByte, &data); err != nil {
+		return fmt.Errorf("fcm: data payload must be a json object: %v", err)
+	}
+
+	this.Message.Data = data
+
+	return nil
+}
+
 // NewFcmRegIdsMsg gets a list of devices with data payload
 func (this *FcmClient) NewFcmRegIdsMsg(list []string, body interface{}) *FcmClient {
 	this.newDevicesList(list)
@@ -147,50 +271,283 @@ func (this *FcmClient) AppendDevices(list []string) *FcmClient {
 	return this
 }
 
+// DedupeDevices removes the duplicated devices/tokens of the Fcm request,
+// keeping the first occurrence of each one in place
+func (this *FcmClient) DedupeDevices() *FcmClient {
+
+	seen := make(map[string]bool, len(this.Message.RegistrationIds))
+	ids := make([]string, 0, len(this.Message.RegistrationIds))
+	for _, id := range this.Message.RegistrationIds {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	this.Message.RegistrationIds = ids
+
+	return this
+}
+
 // apiKeyHeader generates the value of the Authorization key
 func (this *FcmClient) apiKeyHeader() string {
 	return fmt.Sprintf("key=%v", this.ApiKey)
 }
 
+// setHeaders sets the headers common to all the requests
+func (this *FcmClient) setHeaders(request *http.Request) {
+	request.Header.Set("Authorization", this.apiKeyHeader())
+	request.Header.Set("Content-Type", "application/json")
+
+	if this.UserAgent != "" {
+		request.Header.Set("User-Agent", this.UserAgent)
+	} else {
+		request.Header.Set("User-Agent", defaultUserAgent)
+	}
+}
+
+// buildUserAgent returns "go-fcm/<version>" with the version of the module
+// providing this package, "go-fcm" when the build info does not tell it
+func buildUserAgent() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return lib_name
+	}
+
+	return userAgentFor(info, reflect.TypeOf((*FcmClient)(nil)).Elem().PkgPath())
+}
+
+// userAgentFor returns the user agent for the package pkg built as info
+func userAgentFor(info *debug.BuildInfo, pkg string) string {
+	modules := append([]*debug.Module{&info.Main}, info.Deps...)
+	for _, m := range modules {
+		if m.Path == "" || (pkg != m.Path && !strings.HasPrefix(pkg, m.Path+"/")) {
+			continue
+		}
+		if m.Version == "" || m.Version == "(devel)" {
+			break
+		}
+
+		return lib_name + "/" + m.Version
+	}
+
+	return lib_name
+}
+
+// SetUserAgent overrides the default "go-fcm/<version>" user agent
+func (this *FcmClient) SetUserAgent(ua string) *FcmClient {
+
+	this.UserAgent = ua
+
+	return this
+}
+
+// SetEndpoint overrides the fcm server base url (scheme and host, e.g. a
+// mock server or a regional host), "" restores the default
+func (this *FcmClient) SetEndpoint(base string) *FcmClient {
+
+	this.endpoint = strings.TrimSuffix(base, "/")
+
+	return this
+}
+
+// sendUrl returns the legacy send url of the configured endpoint
+func (this *FcmClient) sendUrl() string {
+	if this.endpoint == "" {
+		return fcm_endpoint + fcm_send_path
+	}
+
+	return this.endpoint + fcm_send_path
+}
+
+// SetTimeout sets the wall-clock budget of a send or an instance id
+// request, from waiting for a concurrency slot to reading the response.
+// Each attempt of SendWithRetry and each chunk of a batch topic request
+// gets its own budget. 0 restores the 30s default.
+func (this *FcmClient) SetTimeout(d time.Duration) *FcmClient {
+
+	this.timeout = d
+
+	return this
+}
+
+// sendTimeout returns the configured timeout or the default one
+func (this *FcmClient) sendTimeout() time.Duration {
+	if this.timeout <= 0 {
+		return default_timeout
+	}
+
+	return this.timeout
+}
+
+// httpClient returns the http client shared by all the requests of this
+// FcmClient, so connections are reused between sends
+func (this *FcmClient) httpClient() *http.Client {
+	this.clientMu.Lock()
+	defer this.clientMu.Unlock()
+
+	if this.client == nil {
+		this.client = &http.Client{Transport: newTransport()}
+	}
+
+	return this.client
+}
+
+// newTransport creates the transport used by the client, it honors
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY like the default transport does
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	return transport
+}
+
+// SetProxy routes all the requests through the given proxy url instead of
+// the one found in the environment, it is safe to call while sending
+func (this *FcmClient) SetProxy(proxyUrl string) error {
+
+	u, err := url.Parse(proxyUrl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("fcm: invalid proxy url %q", proxyUrl)
+	}
+
+	transport := newTransport()
+	transport.Proxy = http.ProxyURL(u)
+
+	// swap in a new client, the sends in flight keep the previous one
+	this.clientMu.Lock()
+	previous := this.client
+	this.client = &http.Client{Transport: transport}
+	this.clientMu.Unlock()
+
+	if previous != nil {
+		previous.CloseIdleConnections()
+	}
+
+	return nil
+}
+
 // sendOnce send a single request to fcm
-func (this *FcmClient) sendOnce() (*FcmResponseStatus, error) {
+func (this *FcmClient) sendOnce(ctx context.Context, msg *FcmMsg) (*FcmResponseStatus, error) {
+	return this.sendStreamed(ctx, msg, nil)
+}
+
+// sendStreamed sends a single request to fcm, the results of the response
+// are passed to handler instead of being stored when it is not nil
+func (this *FcmClient) sendStreamed(ctx context.Context, msg *FcmMsg, handler func(i int, result map[string]string)) (*FcmResponseStatus, error) {
 
 	fcmRespStatus := new(FcmResponseStatus)
 
-	jsonByte, err := this.Message.toJsonByte()
+	msg = this.truncateBody(msg)
+
+	jsonByte, err := msg.toJsonByte()
 	if err != nil {
+		this.log().Error("fcm: encoding message failed", "error", err)
 		return fcmRespStatus, err
 	}
 
-	request, err := http.NewRequest("POST", fcmServerUrl, bytes.NewBuffer(jsonByte))
-	request.Header.Set("Authorization", this.apiKeyHeader())
-	request.Header.Set("Content-Type", "application/json")
+	return this.sendEncoded(ctx, msg, jsonByte, handler)
+}
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+// sendEncoded sends msg, already encoded as jsonByte, in a single request
+func (this *FcmClient) sendEncoded(ctx context.Context, msg *FcmMsg, jsonByte []byte, handler func(i int, result map[string]string)) (*FcmResponseStatus, error) {
 
+	fcmRespStatus := new(FcmResponseStatus)
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, this.sendTimeout())
+	defer cancel()
+
+	release, err := this.acquire(ctx)
 	if err != nil {
 		return fcmRespStatus, err
 	}
-	defer response.Body.Close()
+	defer release()
 
-	body, err := ioutil.ReadAll(response.Body)
+	if err := this.breaker.allow(time.Now()); err != nil {
+		return fcmRespStatus, err
+	}
+
+	start := time.Now()
+	fcmRespStatus, err = this.post(ctx, msg, jsonByte, handler)
+	fcmRespStatus.Latency = time.Since(start)
+	fcmRespStatus.Attempts = 1
+
+	switch {
+	case parent.Err() != nil:
+		this.breaker.release()
+	case err != nil && !errors.Is(err, ErrNon200):
+		this.breaker.record(true, time.Now())
+	default:
+		this.breaker.record(fcmRespStatus.ShouldRetry(), time.Now())
+	}
+
+	return fcmRespStatus, err
+}
+
+// post sends the encoded message to fcm and parses the response
+func (this *FcmClient) post(ctx context.Context, msg *FcmMsg, jsonByte []byte, handler func(i int, result map[string]string)) (*FcmResponseStatus, error) {
+
+	fcmRespStatus := new(FcmResponseStatus)
+
+	request, err := http.NewRequestWithContext(ctx, "POST", this.sendUrl(), bytes.NewBuffer(jsonByte))
 	if err != nil {
+		this.log().Error("fcm: creating request failed", "error", err)
 		return fcmRespStatus, err
 	}
+	this.setHeaders(request)
+
+	target := msg.target()
+	this.log().Debug("fcm: sending message", "target", target, "tokens", len(msg.RegistrationIds))
+
+	start := time.Now()
+	response, err := this.httpClient().Do(request)
+
+	if err != nil {
+		this.log().Error("fcm: send failed", "target", target, "error", err, "latency", time.Since(start))
+		return fcmRespStatus, err
+	}
+	defer response.Body.Close()
+
+	this.log().Debug("fcm: response received", "target", target, "status", response.StatusCode, "latency", time.Since(start))
 
 	fcmRespStatus.StatusCode = response.StatusCode
 
 	fcmRespStatus.RetryAfter = response.Header.Get(retry_after_header)
+	fcmRespStatus.Headers = response.Header
+
+	if response.StatusCode == 200 && handler != nil {
+		err = fcmRespStatus.decodeStatusStream(response.Body, handler)
+		if err != nil {
+			this.log().Error("fcm: reading response failed", "target", target, "error", err, "latency", time.Since(start))
+			return fcmRespStatus, err
+		}
+		fcmRespStatus.Ok = true
 
-	if response.StatusCode != 200 {
 		return fcmRespStatus, nil
 	}
 
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		this.log().Error("fcm: reading response failed", "target", target, "error", err, "latency", time.Since(start))
+		return fcmRespStatus, err
+	}
+
+	this.logBody("fcm: response body", body, "target", target)
+	fcmRespStatus.RawBody = body
+
+	if response.StatusCode != 200 {
+		fcmRespStatus.Err = errorFromBody(response.StatusCode, body)
+		return fcmRespStatus, fcmRespStatus.Error()
+	}
+
 	err = fcmRespStatus.parseStatusBody(body)
 	if err != nil {
 		return fcmRespStatus, err
 	}
+	fcmRespStatus.TokenResults = newTokenResults(msg, fcmRespStatus.Results)
 	fcmRespStatus.Ok = true
 
 	return fcmRespStatus, nil
@@ -198,8 +555,89 @@ func (this *FcmClient) sendOnce() (*FcmResponseStatus, error) {
 
 // Send to fcm
 func (this *FcmClient) Send() (*FcmResponseStatus, error) {
-	return this.sendOnce()
+	return this.SendWithContext(context.Background())
+
+}
+
+// SendWithContext sends to fcm, the request is cancelled when ctx is done
+func (this *FcmClient) SendWithContext(ctx context.Context) (*FcmResponseStatus, error) {
+	return this.sendStreamed(ctx, &this.Message, this.resultHandler)
+}
+
+// SendDryRun sends the message with dry_run enabled for this call only,
+// the DryRun field of the message is left untouched
+func (this *FcmClient) SendDryRun(ctx context.Context) (*FcmResponseStatus, error) {
+
+	msg := this.Message
+	msg.DryRun = true
 
+	return this.sendStreamed(ctx, &msg, this.resultHandler)
+}
+
+// BuildMessage returns a copy of the current message that later changes
+// to the client do not affect, the data payload is encoded right away.
+// It can be sent by SendMessage, from several goroutines.
+func (this *FcmClient) BuildMessage() (FcmMsg, error) {
+
+	msg := this.Message
+
+	if msg.Data != nil {
+		data, err := json.Marshal(msg.Data)
+		if err != nil {
+			return FcmMsg{}, err
+		}
+		msg.Data = json.RawMessage(data)
+	}
+
+	msg.RegistrationIds = copyStrings(msg.RegistrationIds)
+	msg.RestrictedPackageList = copyStrings(msg.RestrictedPackageList)
+	msg.Notification.BodyLocArgs = copyStrings(msg.Notification.BodyLocArgs)
+	msg.Notification.TitleLocArgs = copyStrings(msg.Notification.TitleLocArgs)
+
+	return msg, nil
+}
+
+// SendMessage sends msg instead of the current message, it is safe for
+// concurrent use as long as the client settings are not changed meanwhile
+func (this *FcmClient) SendMessage(ctx context.Context, msg FcmMsg) (*FcmResponseStatus, error) {
+	return this.sendOnce(ctx, &msg)
+}
+
+// copyStrings returns a copy of list, nil for an empty list
+func copyStrings(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+
+	return append([]string(nil), list...)
+}
+
+// ValidateToken checks whether fcm considers a registration token valid,
+// by sending a dry run message to it. The current message is not used.
+// It returns false without an error when the token is not registered or
+// invalid, and an error when the token validity is unknown.
+func (this *FcmClient) ValidateToken(ctx context.Context, token string) (bool, error) {
+
+	msg := &FcmMsg{
+		RegistrationIds: []string{token},
+		DryRun:          true,
+	}
+
+	status, err := this.sendOnce(ctx, msg)
+	if err != nil {
+		return false, err
+	}
+
+	for _, result := range status.Results {
+		if reason, failed := result[error_key]; failed {
+			if invalidTokenErrors[reason] {
+				return false, nil
+			}
+			return false, fmt.Errorf("fcm: validating token failed: %s", reason)
+		}
+	}
+
+	return true, nil
 }
 
 // toJsonByte converts FcmMsg to a json byte
@@ -209,6 +647,55 @@ func (this *FcmMsg) toJsonByte() ([]byte, error) {
 
 }
 
+// MarshalJSON encodes the message, leaving out an empty notification
+// (omitempty does not apply to structs), which would turn a data message
+// into a notification message
+func (this FcmMsg) MarshalJSON() ([]byte, error) {
+
+	type plainMsg FcmMsg
+	wire := struct {
+		plainMsg
+		Notification *NotificationPayload `json:"notification,omitempty"`
+	}{plainMsg: plainMsg(this)}
+
+	if !reflect.DeepEqual(this.Notification, NotificationPayload{}) {
+		wire.Notification = &this.Notification
+	}
+
+	return json.Marshal(wire)
+}
+
+// PayloadSizes returns the serialized size in bytes of the message blocks:
+// "notification", "data" and the whole "message", it helps finding what
+// pushes a message over the fcm 4KB limit
+func (this *FcmClient) PayloadSizes() (map[string]int, error) {
+
+	sizes := make(map[string]int)
+
+	notification, err := json.Marshal(this.Message.Notification)
+	if err != nil {
+		return nil, err
+	}
+	sizes["notification"] = len(notification)
+
+	sizes["data"] = 0
+	if this.Message.Data != nil {
+		data, err := json.Marshal(this.Message.Data)
+		if err != nil {
+			return nil, err
+		}
+		sizes["data"] = len(data)
+	}
+
+	message, err := this.Message.toJsonByte()
+	if err != nil {
+		return nil, err
+	}
+	sizes["message"] = len(message)
+
+	return sizes, nil
+}
+
 // parseStatusBody parse FCM response body
 func (this *FcmResponseStatus) parseStatusBody(body []byte) error {
 
@@ -253,6 +740,53 @@ func (this *FcmClient) SetNotificationPayload(payload *NotificationPayload) *Fcm
 	return this
 }
 
+// SetImage sets the url of the image shown in the notification
+func (this *FcmClient) SetImage(url string) *FcmClient {
+
+	this.Message.Notification.Image = url
+
+	return this
+}
+
+// SetBadge sets the notification badge from a number, the legacy
+// protocol expects the badge as a string
+func (this *FcmClient) SetBadge(n int) *FcmClient {
+
+	this.Message.Notification.Badge = strconv.Itoa(n)
+
+	return this
+}
+
+// SetMaxBodyLength limits the notification body to n characters (runes),
+// longer bodies are cut and end with an ellipsis when the message is sent,
+// the message itself is left untouched. n <= 0 means no limit, the default.
+func (this *FcmClient) SetMaxBodyLength(n int) *FcmClient {
+
+	this.maxBodyLength = n
+
+	return this
+}
+
+// truncateBody returns msg, or a copy of it with its notification body
+// truncated to maxBodyLength runes
+func (this *FcmClient) truncateBody(msg *FcmMsg) *FcmMsg {
+	if this.maxBodyLength <= 0 {
+		return msg
+	}
+
+	body := []rune(msg.Notification.Body)
+	if len(body) <= this.maxBodyLength {
+		return msg
+	}
+
+	this.log().Debug("fcm: notification body truncated", "length", len(body), "max", this.maxBodyLength)
+
+	truncated := *msg
+	truncated.Notification.Body = string(body[:this.maxBodyLength-1]) + "…"
+
+	return &truncated
+}
+
 // SetContentAvailable On iOS, use this field to represent content-available
 // in the APNS payload. When a notification or message is sent and this is set
 // to true, an inactive client app is awoken. On Android, data messages wake
@@ -264,6 +798,16 @@ func (this *FcmClient) SetContentAvailable(isContentAvailable bool) *FcmClient {
 	return this
 }
 
+// SetMutableContent On iOS, use this field to represent mutable-content in
+// the APNS payload, so that a Notification Service Extension can modify
+// the notification before it is displayed. Ignored on other platforms.
+func (this *FcmClient) SetMutableContent(isMutableContent bool) *FcmClient {
+
+	this.Message.MutableContent = isMutableContent
+
+	return this
+}
+
 // SetDelayWhileIdle When this parameter is set to true, it indicates that
 // the message should not be sent until the device becomes active.
 // The default value is false.
@@ -293,6 +837,19 @@ func (this *FcmClient) SetTimeToLive(ttl int) *FcmClient {
 	return this
 }
 
+// SetTimeToLiveStrict works like SetTimeToLive but returns an error instead
+// of clamping when ttl is negative or greater than MAX_TTL
+func (this *FcmClient) SetTimeToLiveStrict(ttl int) error {
+
+	if ttl < 0 || ttl > MAX_TTL {
+		return fmt.Errorf("fcm: time to live %d out of range [0, %d]", ttl, MAX_TTL)
+	}
+
+	this.Message.TimeToLive = ttl
+
+	return nil
+}
+
 // SetRestrictedPackageName This parameter specifies the package name of the
 // application where the registration tokens must match in order to
 // receive the message.
@@ -303,6 +860,23 @@ func (this *FcmClient) SetRestrictedPackageName(pkg string) *FcmClient {
 	return this
 }
 
+// SetRestrictedPackageNames restricts the message to several package names,
+// a single name falls back to restricted_package_name
+func (this *FcmClient) SetRestrictedPackageNames(pkgs []string) *FcmClient {
+
+	this.Message.RestrictedPackageName = ""
+	this.Message.RestrictedPackageList = nil
+
+	if len(pkgs) == 1 {
+		this.Message.RestrictedPackageName = pkgs[0]
+	} else if len(pkgs) > 1 {
+		this.Message.RestrictedPackageList = make([]string, len(pkgs))
+		copy(this.Message.RestrictedPackageList, pkgs)
+	}
+
+	return this
+}
+
 // SetDryRun This parameter, when set to true, allows developers to test
 // a request without actually sending a message.
 // The default value is false
@@ -335,6 +909,9 @@ func (this *FcmResponseStatus) IsTimeout() bool {
 	if this.StatusCode >= 500 {
 		return true
 	} else if this.StatusCode == 200 {
+		if this.streamedRetryable > 0 {
+			return true
+		}
 		for _, val := range this.Results {
 			for k, v := range val {
 				if k == error_key && retreyableErrors[v] == true {
@@ -347,11 +924,68 @@ func (this *FcmResponseStatus) IsTimeout() bool {
 	return false
 }
 
+// ShouldRetry reports whether resending the same message may succeed:
+// on a 429 or 5xx response, and on a 200 response whose topic error or
+// results contain a retryable error (Unavailable, InternalServerError).
+// Other 4xx responses and permanent token errors (e.g. NotRegistered) are
+// not retryable. When it returns true, GetRetryAfterTime gives the delay
+// requested by fcm, if any.
+func (this *FcmResponseStatus) ShouldRetry() bool {
+	switch {
+	case this.StatusCode == http.StatusTooManyRequests || this.StatusCode >= 500:
+		return true
+	case this.StatusCode >= 400:
+		return false
+	}
+
+	return retreyableErrors[this.Err] || this.IsTimeout()
+}
+
 // GetRetryAfterTime converts the retrey after response header
-// to a time.Duration
+// to a time.Duration, the header can be a number of seconds ("120"),
+// a duration ("1m30s") or an http date (the delay until that date,
+// 0 when the date is past)
 func (this *FcmResponseStatus) GetRetryAfterTime() (t time.Duration, e error) {
-	t, e = time.ParseDuration(this.RetryAfter)
-	return
+	return parseRetryAfter(this.RetryAfter, time.Now())
+}
+
+// parseRetryAfter parses a Retry-After header value relative to now
+func parseRetryAfter(value string, now time.Time) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("fcm: negative retry after %q", value)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, nil
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, fmt.Errorf("fcm: invalid retry after %q", value)
+	}
+	if date.Before(now) {
+		return 0, nil
+	}
+
+	return date.Sub(now), nil
+}
+
+// SetAnalyticsLabel sets the label used to group the message in the
+// Firebase console reports, it returns an error for an invalid label
+func (this *FcmClient) SetAnalyticsLabel(label string) error {
+
+	if err := ValidateAnalyticsLabel(label); err != nil {
+		return err
+	}
+
+	this.Message.FcmOptions = &FcmOptions{AnalyticsLabel: label}
+
+	return nil
 }
 
 // SetCondition to set a logical expression of conditions that determine the message target
@@ -359,3 +993,28 @@ func (this *FcmClient) SetCondition(condition string) *FcmClient {
 	this.Message.Condition = condition
 	return this
 }
+
+// ValidateCondition checks a condition against the fcm limits, a condition
+// must reference between 1 and max_condition_topics topics
+func ValidateCondition(condition string) error {
+	n := len(conditionTopicRegexp.FindAllString(condition, -1))
+
+	if n == 0 {
+		return fmt.Errorf("fcm: condition %q references no topic", condition)
+	}
+	if n > max_condition_topics {
+		return fmt.Errorf("fcm: condition references %d topics, the limit is %d", n, max_condition_topics)
+	}
+
+	return nil
+}
+
+// ValidateAnalyticsLabel checks an analytics label against the pattern
+// accepted by fcm: 1 to 50 characters among [a-zA-Z0-9-_.~%]
+func ValidateAnalyticsLabel(label string) error {
+	if !analyticsLabelRegexp.MatchString(label) {
+		return fmt.Errorf("fcm: invalid analytics label %q, it must match %s", label, analyticsLabelRegexp)
+	}
+
+	return nil
+}
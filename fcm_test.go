@@ -1,19 +1,26 @@
 package fcm
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestTopicHandle_1(t *testing.T) {
 
 	srv := httptest.NewServer(http.HandlerFunc(topicHandle))
-	chgUrl(srv)
 	defer srv.Close()
 
 	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
 
 	data := map[string]string{
 		"msg": "Hello World",
@@ -34,10 +41,10 @@ func TestTopicHandle_1(t *testing.T) {
 func TestTopicHandle_2(t *testing.T) {
 
 	srv := httptest.NewServer(http.HandlerFunc(topicHandle))
-	chgUrl(srv)
 	defer srv.Close()
 
 	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
 
 	data := map[string]string{
 		"msg": "Hello World",
@@ -58,10 +65,10 @@ func TestTopicHandle_2(t *testing.T) {
 func TestTopicHandle_3(t *testing.T) {
 
 	srv := httptest.NewServer(http.HandlerFunc(topicHandle))
-	chgUrl(srv)
 	defer srv.Close()
 
 	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
 
 	data := map[string]string{
 		"msg": "Hello World",
@@ -87,10 +94,10 @@ func TestTopicHandle_3(t *testing.T) {
 func TestRegIdHandle_1(t *testing.T) {
 
 	srv := httptest.NewServer(http.HandlerFunc(regIdHandle))
-	chgUrl(srv)
 	defer srv.Close()
 
 	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
 
 	data := map[string]string{
 		"msg": "Hello World",
@@ -121,10 +128,10 @@ func TestRegIdHandle_1(t *testing.T) {
 func TestRegIdHandle_2(t *testing.T) {
 
 	srv := httptest.NewServer(http.HandlerFunc(regIdHandle))
-	chgUrl(srv)
 	defer srv.Close()
 
 	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
 
 	data := map[string]string{
 		"msg": "Hello World",
@@ -159,10 +166,6 @@ func TestRegIdHandle_2(t *testing.T) {
 	}
 }
 
-func chgUrl(ts *httptest.Server) {
-	fcmServerUrl = ts.URL
-}
-
 func topicHandle(w http.ResponseWriter, r *http.Request) {
 	result := `{"message_id":6985435902064854329}`
 
@@ -174,3 +177,745 @@ func regIdHandle(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, result)
 
 }
+
+func TestSetProxy(t *testing.T) {
+
+	proxied := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.Host == "fcm.invalid"
+		topicHandle(w, r)
+	}))
+	defer srv.Close()
+	c := NewFcmClient("key")
+	c.SetEndpoint("http://fcm.invalid")
+	if err := c.SetProxy(srv.URL); err != nil {
+		t.Fatal("SetProxy Error : ", err)
+	}
+
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	if _, err := c.Send(); err != nil {
+		t.Error("Response Error : ", err)
+	}
+	if !proxied {
+		t.Error("Request did not go through the proxy")
+	}
+
+	if err := c.SetProxy("not a url"); err == nil {
+		t.Error("Expected an error for an invalid proxy url")
+	}
+}
+
+func TestSetProxyWhileSending(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(topicHandle))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint("http://fcm.invalid")
+	c.SetProxy(srv.URL)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+	msg, err := c.BuildMessage()
+	if err != nil {
+		t.Fatal("BuildMessage Error : ", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.SendMessage(context.Background(), msg); err != nil {
+				t.Error("Response Error : ", err)
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if err := c.SetProxy(srv.URL); err != nil {
+			t.Error("SetProxy Error : ", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestPayloadSizes(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+	c.SetNotificationPayload(&NotificationPayload{Title: "Title"})
+
+	sizes, err := c.PayloadSizes()
+	if err != nil {
+		t.Fatal("PayloadSizes Error : ", err)
+	}
+
+	if sizes["data"] != len(`{"msg":"Hello World"}`) {
+		t.Error("Wrong data size : ", sizes["data"])
+	}
+	if sizes["notification"] != len(`{"title":"Title"}`) {
+		t.Error("Wrong notification size : ", sizes["notification"])
+	}
+	if sizes["message"] <= sizes["data"]+sizes["notification"] {
+		t.Error("Wrong message size : ", sizes["message"])
+	}
+}
+
+func TestSetRestrictedPackageNames(t *testing.T) {
+
+	c := NewFcmClient("key")
+
+	c.SetRestrictedPackageNames([]string{"com.app.free", "com.app.pro"})
+	b, _ := c.Message.toJsonByte()
+	if !strings.Contains(string(b), `"restricted_package_name_list":["com.app.free","com.app.pro"]`) {
+		t.Error("Missing package list : ", string(b))
+	}
+
+	c.SetRestrictedPackageNames([]string{"com.app.free"})
+	b, _ = c.Message.toJsonByte()
+	if !strings.Contains(string(b), `"restricted_package_name":"com.app.free"`) ||
+		strings.Contains(string(b), "restricted_package_name_list") {
+		t.Error("Single package should use restricted_package_name : ", string(b))
+	}
+}
+
+func TestSetTimeToLiveStrict(t *testing.T) {
+
+	c := NewFcmClient("key")
+
+	if err := c.SetTimeToLiveStrict(3600); err != nil || c.Message.TimeToLive != 3600 {
+		t.Error("Valid ttl rejected : ", err)
+	}
+	if err := c.SetTimeToLiveStrict(MAX_TTL + 1); err == nil {
+		t.Error("Expected an error for ttl > MAX_TTL")
+	}
+	if err := c.SetTimeToLiveStrict(-1); err == nil {
+		t.Error("Expected an error for a negative ttl")
+	}
+	if c.Message.TimeToLive != 3600 {
+		t.Error("Rejected ttl changed the message : ", c.Message.TimeToLive)
+	}
+}
+
+func TestSetBadge(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetNotificationPayload(&NotificationPayload{Title: "Title"})
+	c.SetBadge(5)
+
+	b, _ := c.Message.toJsonByte()
+	if !strings.Contains(string(b), `"badge":"5"`) {
+		t.Error("Missing badge : ", string(b))
+	}
+}
+
+func TestUserAgentFor(t *testing.T) {
+
+	info := &debug.BuildInfo{
+		Main: debug.Module{Path: "example.com/app", Version: "(devel)"},
+		Deps: []*debug.Module{
+			{Path: "github.com/NaySoftware/go-fcm", Version: "v1.2.3"},
+		},
+	}
+
+	if ua := userAgentFor(info, "github.com/NaySoftware/go-fcm"); ua != "go-fcm/v1.2.3" {
+		t.Error("Wrong versioned user agent : ", ua)
+	}
+	if ua := userAgentFor(info, "example.com/app/fcm"); ua != "go-fcm" {
+		t.Error("Wrong unversioned user agent : ", ua)
+	}
+	if ua := userAgentFor(info, "other.com/fcm"); ua != "go-fcm" {
+		t.Error("Wrong user agent for an unknown module : ", ua)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+
+	agent := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent = r.UserAgent()
+		topicHandle(w, r)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	c.Send()
+	if agent != defaultUserAgent {
+		t.Error("Wrong default user agent : ", agent)
+	}
+
+	c.SetUserAgent("my-service/2.0")
+	c.Send()
+	if agent != "my-service/2.0" {
+		t.Error("Wrong user agent : ", agent)
+	}
+}
+
+func TestSetStruct(t *testing.T) {
+
+	type payload struct {
+		Msg    string `json:"msg"`
+		Count  int    `json:"count"`
+		Hidden string `json:"-"`
+	}
+
+	c := NewFcmClient("key")
+	if err := c.SetStruct(payload{Msg: "Hello", Count: 2, Hidden: "x"}); err != nil {
+		t.Fatal("SetStruct Error : ", err)
+	}
+
+	data, ok := c.Message.Data.(map[string]interface{})
+	if !ok || data["msg"] != "Hello" || data["count"] != float64(2) || len(data) != 2 {
+		t.Error("Wrong data payload : ", c.Message.Data)
+	}
+
+	if err := c.SetStruct([]string{"not", "an", "object"}); err == nil {
+		t.Error("Expected an error for a non object payload")
+	}
+	if err := c.SetStruct(make(chan int)); err == nil {
+		t.Error("Expected an error for a non marshalable payload")
+	}
+	if data, ok := c.Message.Data.(map[string]interface{}); !ok || data["msg"] != "Hello" {
+		t.Error("Failed SetStruct changed the data payload : ", c.Message.Data)
+	}
+}
+
+func TestLocArgsArray(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetNotificationPayload(&NotificationPayload{
+		BodyLocKey:   "body_key",
+		BodyLocArgs:  []string{"Alice", "5"},
+		TitleLocKey:  "title_key",
+		TitleLocArgs: []string{"Bob"},
+	})
+
+	b, _ := c.Message.toJsonByte()
+	if !strings.Contains(string(b), `"body_loc_args":["Alice","5"]`) ||
+		!strings.Contains(string(b), `"title_loc_args":["Bob"]`) {
+		t.Error("Loc args not encoded as arrays : ", string(b))
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+
+		if !msg.DryRun {
+			t.Error("Validation must be a dry run")
+		}
+
+		switch msg.RegistrationIds[0] {
+		case "valid":
+			fmt.Fprintln(w, `{"multicast_id":1,"success":1,"failure":0,"results":[{"message_id":"fake_message_id"}]}`)
+		case "unavailable":
+			fmt.Fprintln(w, `{"multicast_id":1,"success":0,"failure":1,"results":[{"error":"Unavailable"}]}`)
+		default:
+			fmt.Fprintln(w, `{"multicast_id":1,"success":0,"failure":1,"results":[{"error":"NotRegistered"}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	if ok, err := c.ValidateToken(context.Background(), "valid"); !ok || err != nil {
+		t.Error("Valid token reported invalid : ", err)
+	}
+	if ok, err := c.ValidateToken(context.Background(), "stale"); ok || err != nil {
+		t.Error("Unregistered token reported valid : ", err)
+	}
+	if _, err := c.ValidateToken(context.Background(), "unavailable"); err == nil {
+		t.Error("Expected an error when fcm is unavailable")
+	}
+	if c.Message.To != "/topics/topicName" || c.Message.DryRun {
+		t.Error("ValidateToken changed the message")
+	}
+}
+
+func TestSendInvalidUrl(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetEndpoint("://invalid")
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	if _, err := c.Send(); err == nil {
+		t.Error("Expected an error for an invalid url")
+	}
+}
+
+func TestValidateCondition(t *testing.T) {
+
+	valid := "'TopicA' in topics && ('TopicB' in topics || 'TopicC' in topics)"
+	if err := ValidateCondition(valid); err != nil {
+		t.Error("Valid condition rejected : ", err)
+	}
+
+	tooMany := "'a' in topics || 'b' in topics || 'c' in topics || 'd' in topics || 'e' in topics || 'f' in topics"
+	if err := ValidateCondition(tooMany); err == nil || !strings.Contains(err.Error(), "limit is 5") {
+		t.Error("Expected the topics limit error, got ", err)
+	}
+
+	if err := ValidateCondition("TopicA"); err == nil {
+		t.Error("Expected an error for a condition without topic")
+	}
+}
+
+func TestSendLatencyAndAttempts(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(topicHandle))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	res, err := c.Send()
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+	if res.Attempts != 1 || res.Latency <= 0 {
+		t.Error("Wrong attempts or latency : ", res.Attempts, res.Latency)
+	}
+}
+
+func TestValidateAnalyticsLabel(t *testing.T) {
+
+	for _, label := range []string{"campaign_2024-05", "a.b~c%20"} {
+		if err := ValidateAnalyticsLabel(label); err != nil {
+			t.Error("Valid label rejected : ", err)
+		}
+	}
+
+	for _, label := range []string{"", "spring campaign", strings.Repeat("a", 51)} {
+		if err := ValidateAnalyticsLabel(label); err == nil {
+			t.Error("Invalid label accepted : ", label)
+		}
+	}
+}
+
+func TestResetMessage(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetUserAgent("my-service/2.0")
+	c.NewFcmRegIdsMsg([]string{"token0"}, map[string]string{"msg": "Hello World"})
+	c.SetDryRun(true)
+
+	c.ResetMessage()
+
+	if c.Message.Data != nil || len(c.Message.RegistrationIds) != 0 || c.Message.DryRun {
+		t.Error("Message not reset : ", c.Message)
+	}
+	if c.ApiKey != "key" || c.UserAgent != "my-service/2.0" {
+		t.Error("ResetMessage changed the client settings")
+	}
+}
+
+func TestSendDryRun(t *testing.T) {
+
+	dryRuns := []bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+		dryRuns = append(dryRuns, msg.DryRun)
+		topicHandle(w, r)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	if _, err := c.SendDryRun(context.Background()); err != nil {
+		t.Error("Response Error : ", err)
+	}
+	if _, err := c.Send(); err != nil {
+		t.Error("Response Error : ", err)
+	}
+
+	if len(dryRuns) != 2 || !dryRuns[0] || dryRuns[1] || c.Message.DryRun {
+		t.Error("Dry run leaked into the message : ", dryRuns)
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "abc")
+		topicHandle(w, r)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	res, err := c.Send()
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+	if res.Headers.Get("X-Request-Id") != "abc" {
+		t.Error("Response headers not captured : ", res.Headers)
+	}
+}
+
+func TestResponseStatusJsonRoundTrip(t *testing.T) {
+
+	status := &FcmResponseStatus{
+		Ok:            true,
+		StatusCode:    200,
+		MulticastId:   1003859738309903334,
+		Success:       1,
+		Fail:          1,
+		Canonical_ids: 0,
+		Results:       []map[string]string{{"message_id": "0:1"}, {"error": "NotRegistered"}},
+		RetryAfter:    "120",
+		Headers:       http.Header{"X-Request-Id": []string{"abc"}},
+		Latency:       15 * time.Millisecond,
+		Attempts:      1,
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal("Marshal Error : ", err)
+	}
+	if !strings.Contains(string(b), `"status_code":200`) || !strings.Contains(string(b), `"retry_after":"120"`) {
+		t.Error("Missing json field names : ", string(b))
+	}
+
+	decoded := new(FcmResponseStatus)
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatal("Unmarshal Error : ", err)
+	}
+	if !reflect.DeepEqual(status, decoded) {
+		t.Error("Round trip mismatch : ", decoded)
+	}
+}
+
+func TestNewDataMessage(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetNotificationPayload(&NotificationPayload{Title: "Title"})
+	c.NewDataMessage("token0", map[string]string{"sync": "inbox"})
+
+	b, _ := c.Message.toJsonByte()
+	msg := string(b)
+
+	if strings.Contains(msg, "notification") {
+		t.Error("Data message must not have a notification : ", msg)
+	}
+	if !strings.Contains(msg, `"priority":"high"`) || !strings.Contains(msg, `"content_available":true`) {
+		t.Error("Missing data message defaults : ", msg)
+	}
+}
+
+func TestEmptyNotificationOmitted(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.NewFcmMsgTo("token0", map[string]string{"msg": "Hello World"})
+
+	b, err := c.Message.toJsonByte()
+	if err != nil {
+		t.Fatal("Encoding Error : ", err)
+	}
+	if strings.Contains(string(b), "notification") {
+		t.Error("Empty notification encoded : ", string(b))
+	}
+
+	c.Message.Notification.Title = "Title"
+	b, _ = json.Marshal(FcmMsg{To: "token0", Notification: c.Message.Notification})
+	if !strings.Contains(string(b), `"notification":{"title":"Title"}`) {
+		t.Error("Notification not encoded : ", string(b))
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+
+	cases := []struct {
+		status   FcmResponseStatus
+		expected bool
+	}{
+		{FcmResponseStatus{StatusCode: 200, Results: []map[string]string{{"message_id": "1"}}}, false},
+		{FcmResponseStatus{StatusCode: 200, Results: []map[string]string{{"error": "Unavailable"}}}, true},
+		{FcmResponseStatus{StatusCode: 200, Results: []map[string]string{{"error": "NotRegistered"}}}, false},
+		{FcmResponseStatus{StatusCode: 200, Err: "InternalServerError"}, true},
+		{FcmResponseStatus{StatusCode: 429, RetryAfter: "10"}, true},
+		{FcmResponseStatus{StatusCode: 503}, true},
+		{FcmResponseStatus{StatusCode: 400}, false},
+		{FcmResponseStatus{StatusCode: 401}, false},
+	}
+
+	for i, c := range cases {
+		if c.status.ShouldRetry() != c.expected {
+			t.Error("Wrong retry decision for case ", i)
+		}
+	}
+}
+
+func TestDedupeDevices(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.NewFcmRegIdsMsg([]string{"token0", "token1", "token0"}, nil)
+	c.AppendDevices([]string{"token2", "token1"})
+
+	c.DedupeDevices()
+
+	if !reflect.DeepEqual(c.Message.RegistrationIds, []string{"token0", "token1", "token2"}) {
+		t.Error("Wrong deduped devices : ", c.Message.RegistrationIds)
+	}
+}
+
+func TestTruncateBody(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetNotificationPayload(&NotificationPayload{Body: "héllo wörld"})
+
+	if msg := c.truncateBody(&c.Message); msg != &c.Message {
+		t.Error("Body truncated without a limit")
+	}
+
+	c.SetMaxBodyLength(6)
+	msg := c.truncateBody(&c.Message)
+	if msg.Notification.Body != "héllo…" {
+		t.Error("Wrong truncated body : ", msg.Notification.Body)
+	}
+	if c.Message.Notification.Body != "héllo wörld" {
+		t.Error("Truncation changed the message")
+	}
+
+	c.SetMaxBodyLength(11)
+	if msg := c.truncateBody(&c.Message); msg.Notification.Body != "héllo wörld" {
+		t.Error("Body truncated under the limit : ", msg.Notification.Body)
+	}
+}
+
+func TestSendWithContextCancel(t *testing.T) {
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.SendWithContext(ctx); err == nil {
+		t.Error("Expected the deadline error")
+	}
+}
+
+func TestBuildMessageSendMessage(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+		fmt.Fprintf(w, `{"results":[{"message_id":%q}]}`, msg.To)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	data := map[string]string{"msg": "Hello World"}
+	c.NewFcmMsgTo("token0", data)
+
+	msg, err := c.BuildMessage()
+	if err != nil {
+		t.Fatal("BuildMessage Error : ", err)
+	}
+
+	// later changes must not leak into the built message
+	data["msg"] = "changed"
+	c.NewFcmMsgTo("token1", data)
+
+	b, _ := msg.toJsonByte()
+	if !strings.Contains(string(b), `"data":{"msg":"Hello World"}`) || msg.To != "token0" {
+		t.Error("Built message changed : ", string(b))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			m := msg
+			m.To = fmt.Sprintf("token%d", i)
+			res, err := c.SendMessage(context.Background(), m)
+			if err != nil || res.Results[0]["message_id"] != m.To {
+				t.Error("Wrong concurrent send : ", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err := (&FcmClient{Message: FcmMsg{Data: make(chan int)}}).BuildMessage(); err == nil {
+		t.Error("Expected an error for a non marshalable payload")
+	}
+}
+
+func TestSetImage(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetNotificationPayload(&NotificationPayload{Title: "Title"})
+	c.SetImage("https://example.com/image.png")
+
+	b, _ := c.Message.toJsonByte()
+	if !strings.Contains(string(b), `"image":"https://example.com/image.png"`) {
+		t.Error("Missing image : ", string(b))
+	}
+}
+
+func TestResponseRawBody(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(topicHandle))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	res, err := c.Send()
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+	if strings.TrimSpace(string(res.RawBody)) != `{"message_id":6985435902064854329}` {
+		t.Error("Wrong raw body : ", string(res.RawBody))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+
+	now := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)
+
+	cases := map[string]time.Duration{
+		"120":                           120 * time.Second,
+		"1m30s":                         90 * time.Second,
+		"Wed, 21 Oct 2015 07:30:00 GMT": 2 * time.Minute,
+		"Wed, 21 Oct 2015 07:00:00 GMT": 0,
+	}
+
+	for value, expected := range cases {
+		d, err := parseRetryAfter(value, now)
+		if err != nil || d != expected {
+			t.Error("Wrong retry after for ", value, " : ", d, err)
+		}
+	}
+
+	for _, value := range []string{"", "soon", "-5"} {
+		if _, err := parseRetryAfter(value, now); err == nil {
+			t.Error("Expected an error for ", value)
+		}
+	}
+
+	status := &FcmResponseStatus{RetryAfter: "10"}
+	if d, err := status.GetRetryAfterTime(); err != nil || d != 10*time.Second {
+		t.Error("Wrong GetRetryAfterTime : ", d, err)
+	}
+}
+
+func TestSetTopic(t *testing.T) {
+
+	c := NewFcmClient("key")
+
+	c.SetTopic("news")
+	if c.Message.To != "/topics/news" {
+		t.Error("Wrong topic target : ", c.Message.To)
+	}
+
+	c.SetTopic("/topics/sports")
+	if c.Message.To != "/topics/sports" {
+		t.Error("Wrong topic target : ", c.Message.To)
+	}
+
+	c.NewFcmRegIdsMsg([]string{"token0"}, nil)
+	if err := c.Validate(); err == nil {
+		t.Error("Expected an error for a topic and registration ids")
+	}
+}
+
+func TestSetAnalyticsLabel(t *testing.T) {
+
+	c := NewFcmClient("key")
+
+	if err := c.SetAnalyticsLabel("spring_campaign"); err != nil {
+		t.Fatal("Valid label rejected : ", err)
+	}
+	b, _ := c.Message.toJsonByte()
+	if !strings.Contains(string(b), `"fcm_options":{"analytics_label":"spring_campaign"}`) {
+		t.Error("Missing analytics label : ", string(b))
+	}
+
+	if err := c.SetAnalyticsLabel("spring campaign"); err == nil {
+		t.Error("Invalid label accepted")
+	}
+	if c.Message.FcmOptions.AnalyticsLabel != "spring_campaign" {
+		t.Error("Invalid label changed the message")
+	}
+}
+
+func TestSetMutableContent(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetMutableContent(true)
+
+	b, _ := c.Message.toJsonByte()
+	if !strings.Contains(string(b), `"mutable_content":true`) {
+		t.Error("Missing mutable content : ", string(b))
+	}
+}
+
+func TestSetEndpoint(t *testing.T) {
+
+	c := NewFcmClient("key")
+	if c.sendUrl() != "https://fcm.googleapis.com/fcm/send" {
+		t.Error("Wrong default send url : ", c.sendUrl())
+	}
+
+	c.SetEndpoint("http://fcm.eu.example/")
+	if c.sendUrl() != "http://fcm.eu.example/fcm/send" {
+		t.Error("Wrong send url : ", c.sendUrl())
+	}
+
+	c.SetInstanceIdEndpoint("http://iid.example")
+	if c.iidUrl(batch_add_path) != "http://iid.example/iid/v1:batchAdd" {
+		t.Error("Wrong iid url : ", c.iidUrl(batch_add_path))
+	}
+}
+
+func TestSetTimeout(t *testing.T) {
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.SetTimeout(50 * time.Millisecond)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	start := time.Now()
+	_, err := c.Send()
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Error("Expected a deadline error : ", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Timeout not enforced : ", time.Since(start))
+	}
+}
@@ -2,32 +2,37 @@ package fcm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 )
 
 const (
-	// instance_id_info_with_details_srv_url
-	instance_id_info_with_details_srv_url = "https://iid.googleapis.com/iid/info/%s?details=true"
+	// iid_endpoint default instance id server base url
+	iid_endpoint = "https://iid.googleapis.com"
 
-	// instance_id_info_no_details_srv_url
-	instance_id_info_no_details_srv_url = "https://iid.googleapis.com/iid/info/%s"
+	// instance_id_info_with_details_path
+	instance_id_info_with_details_path = "/iid/info/%s?details=true"
 
-	// subscribe_instanceid_to_topic_srv_url
-	subscribe_instanceid_to_topic_srv_url = "https://iid.googleapis.com/iid/v1/%s/rel/topics/%s"
+	// instance_id_info_no_details_path
+	instance_id_info_no_details_path = "/iid/info/%s"
 
-	// batch_add_srv_url
-	batch_add_srv_url = "https://iid.googleapis.com/iid/v1:batchAdd"
+	// subscribe_instanceid_to_topic_path
+	subscribe_instanceid_to_topic_path = "/iid/v1/%s/rel/topics/%s"
 
-	// batch_rem_srv_url
-	batch_rem_srv_url = "https://iid.googleapis.com/iid/v1:batchRemove"
+	// batch_add_path
+	batch_add_path = "/iid/v1:batchAdd"
 
-	// apns_batch_import_srv_url
-	apns_batch_import_srv_url = "https://iid.googleapis.com/iid/v1:batchImport"
+	// batch_rem_path
+	batch_rem_path = "/iid/v1:batchRemove"
+
+	// apns_batch_import_path
+	apns_batch_import_path = "/iid/v1:batchImport"
 
 	// apns_token_key
 	apns_token_key = "apns_token"
@@ -38,6 +43,9 @@ const (
 
 	// topics
 	topics = "/topics/"
+
+	// max_batch_tokens max number of tokens per batchAdd/batchRemove request
+	max_batch_tokens = 1000
 )
 
 var (
@@ -79,10 +87,21 @@ type BatchRequest struct {
 
 // BatchResponse add/remove response
 type BatchResponse struct {
-	Error      string              `json:"error,omitempty"`
-	Results    []map[string]string `json:"results,omitempty"`
-	Status     string
-	StatusCode int
+	Error        string              `json:"error,omitempty"`
+	Results      []map[string]string `json:"results,omitempty"`
+	Status       string
+	StatusCode   int
+	SuccessCount int
+	FailureCount int
+	Errors       []TopicManagementError
+}
+
+// TopicManagementError failure of a token in a batch add/remove request,
+// Index is the position of Token in the request tokens
+type TopicManagementError struct {
+	Index  int
+	Token  string
+	Reason string
 }
 
 // ApnsBatchRequest apns import request
@@ -103,22 +122,22 @@ type ApnsBatchResponse struct {
 // GetInfo gets the instance id info
 func (this *FcmClient) GetInfo(withDetails bool, instanceIdToken string) (*InstanceIdInfoResponse, error) {
 
-	var request_url string = generateGetInfoUrl(instance_id_info_no_details_srv_url, instanceIdToken)
+	var request_url string = generateGetInfoUrl(this.iidUrl(instance_id_info_no_details_path), instanceIdToken)
 
 	if withDetails == true {
-		request_url = generateGetInfoUrl(instance_id_info_with_details_srv_url, instanceIdToken)
+		request_url = generateGetInfoUrl(this.iidUrl(instance_id_info_with_details_path), instanceIdToken)
 	}
 
-	request, err := http.NewRequest("GET", request_url, nil)
-	request.Header.Set("Authorization", this.apiKeyHeader())
-	request.Header.Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(context.Background(), this.sendTimeout())
+	defer cancel()
 
+	request, err := http.NewRequestWithContext(ctx, "GET", request_url, nil)
 	if err != nil {
 		return nil, err
 	}
+	this.setHeaders(request)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := this.httpClient().Do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -138,6 +157,23 @@ func (this *FcmClient) GetInfo(withDetails bool, instanceIdToken string) (*Insta
 	return infoResponse, nil
 }
 
+// GetTokenInfo gets the detailed instance id info of a token: app package,
+// platform, authorized entity and topic subscriptions (see Topics)
+func (this *FcmClient) GetTokenInfo(instanceIdToken string) (*InstanceIdInfoResponse, error) {
+	return this.GetInfo(true, instanceIdToken)
+}
+
+// Topics returns the topics the instance is subscribed to, with the date
+// each subscription was added
+func (this *InstanceIdInfoResponse) Topics() map[string]string {
+	result := make(map[string]string)
+	for topic, info := range this.Rel["topics"] {
+		result[topic] = info["addDate"]
+	}
+
+	return result
+}
+
 // parseGetInfo parses response to InstanceIdInfoResponse
 func parseGetInfo(body []byte) (*InstanceIdInfoResponse, error) {
 
@@ -172,6 +208,24 @@ func (this *InstanceIdInfoResponse) PrintResults() {
 	}
 }
 
+// SetInstanceIdEndpoint overrides the instance id server base url used by
+// the topic and info helpers, "" restores the default
+func (this *FcmClient) SetInstanceIdEndpoint(base string) *FcmClient {
+
+	this.iidEndpoint = strings.TrimSuffix(base, "/")
+
+	return this
+}
+
+// iidUrl returns path on the configured instance id endpoint
+func (this *FcmClient) iidUrl(path string) string {
+	if this.iidEndpoint == "" {
+		return iid_endpoint + path
+	}
+
+	return this.iidEndpoint + path
+}
+
 // generateGetInfoUrl generate based on with details and the instance token
 func generateGetInfoUrl(srv string, instanceIdToken string) string {
 	return fmt.Sprintf(srv, instanceIdToken)
@@ -180,16 +234,16 @@ func generateGetInfoUrl(srv string, instanceIdToken string) string {
 // SubscribeToTopic subscribes a single device/token to a topic
 func (this *FcmClient) SubscribeToTopic(instanceIdToken string, topic string) (*SubscribeResponse, error) {
 
-	request, err := http.NewRequest("POST", generateSubToTopicUrl(instanceIdToken, topic), nil)
-	request.Header.Set("Authorization", this.apiKeyHeader())
-	request.Header.Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(context.Background(), this.sendTimeout())
+	defer cancel()
 
+	request, err := http.NewRequestWithContext(ctx, "POST", generateSubToTopicUrl(this.iidUrl(subscribe_instanceid_to_topic_path), instanceIdToken, topic), nil)
 	if err != nil {
 		return nil, err
 	}
+	this.setHeaders(request)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := this.httpClient().Do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -235,77 +289,107 @@ func (this *SubscribeResponse) PrintResults() {
 }
 
 // generateSubToTopicUrl generates a url based on the instnace id and topic name
-func generateSubToTopicUrl(instaceId string, topic string) string {
+func generateSubToTopicUrl(srv string, instaceId string, topic string) string {
 	Tmptopic := strings.ToLower(topic)
 	if strings.Contains(Tmptopic, "/topics/") {
 		tmp := strings.Split(topic, "/")
 		topic = tmp[len(tmp)-1]
 	}
-	return fmt.Sprintf(subscribe_instanceid_to_topic_srv_url, instaceId, topic)
+	return fmt.Sprintf(srv, instaceId, topic)
 }
 
-// BatchSubscribeToTopic subscribes (many) devices/tokens to a given topic
+// BatchSubscribeToTopic subscribes (many) devices/tokens to a given topic,
+// lists longer than max_batch_tokens are sent in concurrent chunks, when a
+// chunk fails the merged response is returned along with the error
 func (this *FcmClient) BatchSubscribeToTopic(tokens []string, topic string) (*BatchResponse, error) {
+	return this.batchTopicRequest(this.iidUrl(batch_add_path), tokens, topic)
+}
 
-	jsonByte, err := generateBatchRequest(tokens, topic)
-	if err != nil {
+// BatchUnsubscribeFromTopic unsubscribes (many) devices/tokens from a given topic,
+// lists longer than max_batch_tokens are sent in concurrent chunks, when a
+// chunk fails the merged response is returned along with the error
+func (this *FcmClient) BatchUnsubscribeFromTopic(tokens []string, topic string) (*BatchResponse, error) {
+	return this.batchTopicRequest(this.iidUrl(batch_rem_path), tokens, topic)
+}
+
+// batchTopicRequest splits tokens in chunks of max_batch_tokens, sends them
+// concurrently and merges the responses, keeping the results in tokens order.
+// The tokens of a failed chunk get its error as result, and the first error
+// is returned along with the merged response.
+func (this *FcmClient) batchTopicRequest(srvUrl string, tokens []string, topic string) (*BatchResponse, error) {
+
+	result, err := this.batchTopicRequestChunks(srvUrl, tokens, topic)
+	if result == nil {
 		return nil, err
 	}
+	result.countResults(tokens)
 
-	request, err := http.NewRequest("POST", batch_add_srv_url, bytes.NewBuffer(jsonByte))
-	request.Header.Set("Authorization", this.apiKeyHeader())
-	request.Header.Set("Content-Type", "application/json")
+	return result, err
+}
 
-	if err != nil {
-		fmt.Println(err)
-	}
+// batchTopicRequestChunks sends the chunks and merges their responses
+func (this *FcmClient) batchTopicRequestChunks(srvUrl string, tokens []string, topic string) (*BatchResponse, error) {
 
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		fmt.Println(err)
+	if len(tokens) <= max_batch_tokens {
+		return this.batchTopicRequestOnce(srvUrl, tokens, topic)
 	}
 
-	defer response.Body.Close()
+	chunks := splitTokens(tokens, max_batch_tokens)
+	responses := make([]*BatchResponse, len(chunks))
+	errs := make([]error, len(chunks))
 
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
-	}
-	result, err := generateBatchResponse(body)
-	if err != nil {
-		return nil, err
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []string) {
+			defer wg.Done()
+			responses[i], errs[i] = this.batchTopicRequestOnce(srvUrl, chunk, topic)
+		}(i, chunk)
 	}
-	if result == nil {
-		return nil, errors.New("Parsing response error")
+	wg.Wait()
+
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if responses[i] == nil {
+			responses[i] = &BatchResponse{Error: err.Error()}
+		}
 	}
-	result.Status = response.Status
-	result.StatusCode = response.StatusCode
 
-	return result, nil
+	return mergeBatchResponses(chunks, responses), firstErr
 }
 
-// BatchUnsubscribeFromTopic unsubscribes (many) devices/tokens from a given topic
-func (this *FcmClient) BatchUnsubscribeFromTopic(tokens []string, topic string) (*BatchResponse, error) {
+// batchTopicRequestOnce sends a single batchAdd/batchRemove request
+func (this *FcmClient) batchTopicRequestOnce(srvUrl string, tokens []string, topic string) (*BatchResponse, error) {
 
-	jsonByte, err := generateBatchRequest(tokens, topic)
+	ctx, cancel := context.WithTimeout(context.Background(), this.sendTimeout())
+	defer cancel()
+
+	release, err := this.acquire(ctx)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
+	defer release()
 
-	request, err := http.NewRequest("POST", batch_rem_srv_url, bytes.NewBuffer(jsonByte))
-	request.Header.Set("Authorization", this.apiKeyHeader())
-	request.Header.Set("Content-Type", "application/json")
+	jsonByte, err := generateBatchRequest(tokens, topic)
+	if err != nil {
+		return nil, err
+	}
 
+	request, err := http.NewRequestWithContext(ctx, "POST", srvUrl, bytes.NewBuffer(jsonByte))
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
+	this.setHeaders(request)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := this.httpClient().Do(request)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 
 	defer response.Body.Close()
@@ -316,7 +400,7 @@ func (this *FcmClient) BatchUnsubscribeFromTopic(tokens []string, topic string)
 	}
 	result, err := generateBatchResponse(body)
 	if err != nil {
-		return nil, err
+		return &BatchResponse{Status: response.Status, StatusCode: response.StatusCode}, err
 	}
 	if result == nil {
 		return nil, errors.New("Parsing response error")
@@ -327,6 +411,74 @@ func (this *FcmClient) BatchUnsubscribeFromTopic(tokens []string, topic string)
 	return result, nil
 }
 
+// splitTokens splits tokens in chunks of at most size tokens
+func splitTokens(tokens []string, size int) [][]string {
+	chunks := make([][]string, 0, (len(tokens)+size-1)/size)
+	for len(tokens) > size {
+		chunks = append(chunks, tokens[:size])
+		tokens = tokens[size:]
+	}
+
+	return append(chunks, tokens)
+}
+
+// mergeBatchResponses merges the chunks responses into one, the first failed
+// chunk gives its status and error, and its tokens get that error as result
+func mergeBatchResponses(chunks [][]string, responses []*BatchResponse) *BatchResponse {
+	result := new(BatchResponse)
+
+	for i, resp := range responses {
+		if i == 0 || (result.StatusCode == http.StatusOK && resp.StatusCode != http.StatusOK) {
+			result.Status = resp.Status
+			result.StatusCode = resp.StatusCode
+			result.Error = resp.Error
+		}
+
+		if len(resp.Results) == len(chunks[i]) {
+			result.Results = append(result.Results, resp.Results...)
+			continue
+		}
+
+		failure := resp.Error
+		if failure == "" {
+			failure = resp.Status
+		}
+		for range chunks[i] {
+			result.Results = append(result.Results, map[string]string{error_key: failure})
+		}
+	}
+
+	return result
+}
+
+// countResults fills the success/failure counts and the errors per token,
+// all the tokens failed when the results do not match them
+func (this *BatchResponse) countResults(tokens []string) {
+	this.SuccessCount = 0
+	this.FailureCount = 0
+	this.Errors = nil
+
+	for i, token := range tokens {
+		reason := ""
+		if len(this.Results) != len(tokens) {
+			reason = this.Error
+			if reason == "" {
+				reason = this.Status
+			}
+		} else {
+			reason = this.Results[i][error_key]
+		}
+
+		if reason == "" {
+			this.SuccessCount++
+			continue
+		}
+
+		this.FailureCount++
+		this.Errors = append(this.Errors, TopicManagementError{Index: i, Token: token, Reason: reason})
+	}
+}
+
 // PrintResults prints BatchResponse, for faster debugging
 func (this *BatchResponse) PrintResults() {
 	fmt.Println("Error       : ", this.Error)
@@ -383,16 +535,16 @@ func (this *FcmClient) ApnsBatchImportRequest(apnsReq *ApnsBatchRequest) (*ApnsB
 		return nil, err
 	}
 
-	request, err := http.NewRequest("POST", apns_batch_import_srv_url, bytes.NewBuffer(jsonByte))
-	request.Header.Set("Authorization", this.apiKeyHeader())
-	request.Header.Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(context.Background(), this.sendTimeout())
+	defer cancel()
 
+	request, err := http.NewRequestWithContext(ctx, "POST", this.iidUrl(apns_batch_import_path), bytes.NewBuffer(jsonByte))
 	if err != nil {
 		return nil, err
 	}
+	this.setHeaders(request)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := this.httpClient().Do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -1,12 +1,18 @@
 package fcm
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
 	"testing"
 )
 
 func TestGenTopicUrl(t *testing.T) {
 	expected := "https://iid.googleapis.com/iid/v1/DeviceToken/rel/topics/TopicNamE"
-	result := generateSubToTopicUrl("DeviceToken", "TopicNamE")
+	result := generateSubToTopicUrl(iid_endpoint+subscribe_instanceid_to_topic_path, "DeviceToken", "TopicNamE")
 
 	if result != expected {
 		t.Error("Gen Topic Url Error")
@@ -128,3 +134,170 @@ func TestExtractTopicName(t *testing.T) {
 	}
 
 }
+
+func TestBatchTopicRequestChunks(t *testing.T) {
+
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := new(BatchRequest)
+		json.NewDecoder(r.Body).Decode(req)
+
+		mu.Lock()
+		requests++
+		mu.Unlock()
+
+		results := make([]map[string]string, len(req.RegTokens))
+		for i, token := range req.RegTokens {
+			results[i] = map[string]string{}
+			if token == "bad" {
+				results[i][error_key] = "INVALID_ARGUMENT"
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	defer srv.Close()
+
+	tokens := make([]string, 2500)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token%d", i)
+	}
+	tokens[1500] = "bad"
+
+	c := NewFcmClient("key")
+	resp, err := c.batchTopicRequest(srv.URL, tokens, "/topics/news")
+	if err != nil {
+		t.Fatal("Batch Error : ", err)
+	}
+
+	if requests != 3 {
+		t.Error("Expected 3 chunked requests, got ", requests)
+	}
+	if len(resp.Results) != len(tokens) {
+		t.Fatal("Expected one result per token, got ", len(resp.Results))
+	}
+	for i, res := range resp.Results {
+		if (i == 1500) != (res[error_key] != "") {
+			t.Error("Result out of order at ", i)
+		}
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Wrong status code : ", resp.StatusCode)
+	}
+}
+
+func TestBatchTopicRequestPartialFailure(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := new(BatchRequest)
+		json.NewDecoder(r.Body).Decode(req)
+
+		if req.RegTokens[0] == "token1000" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("<HTML>Service Unavailable</HTML>"))
+			return
+		}
+		results := make([]map[string]string, len(req.RegTokens))
+		for i := range results {
+			results[i] = map[string]string{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	defer srv.Close()
+
+	tokens := make([]string, 2500)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token%d", i)
+	}
+
+	c := NewFcmClient("key")
+	resp, err := c.batchTopicRequest(srv.URL, tokens, "news")
+	if err == nil {
+		t.Error("Expected the failed chunk error")
+	}
+	if resp == nil {
+		t.Fatal("Merged response dropped")
+	}
+
+	if resp.SuccessCount != 1500 || resp.FailureCount != 1000 || resp.StatusCode != 503 {
+		t.Error("Wrong merged response : ", resp.SuccessCount, resp.FailureCount, resp.StatusCode)
+	}
+	if resp.Errors[0].Index != 1000 || resp.Errors[0].Reason != "503 Service Unavailable" {
+		t.Error("Wrong failed chunk error : ", resp.Errors[0])
+	}
+}
+
+func TestMergeBatchResponsesFailedChunk(t *testing.T) {
+
+	chunks := splitTokens([]string{"a", "b", "c"}, 2)
+	if len(chunks) != 2 || len(chunks[0]) != 2 || len(chunks[1]) != 1 {
+		t.Fatal("Wrong chunks : ", chunks)
+	}
+
+	responses := []*BatchResponse{
+		{Results: []map[string]string{{}, {}}, Status: "200 OK", StatusCode: 200},
+		{Error: "Unauthorized", Status: "401 Unauthorized", StatusCode: 401},
+	}
+
+	resp := mergeBatchResponses(chunks, responses)
+	if resp.StatusCode != 401 || resp.Error != "Unauthorized" {
+		t.Error("Failed chunk status not reported : ", resp.StatusCode, resp.Error)
+	}
+	if len(resp.Results) != 3 || resp.Results[2][error_key] != "Unauthorized" {
+		t.Error("Failed chunk results not filled : ", resp.Results)
+	}
+}
+
+func TestBatchResponseCounts(t *testing.T) {
+
+	resp := &BatchResponse{Results: []map[string]string{{}, {error_key: "NOT_FOUND"}, {}}}
+	resp.countResults([]string{"a", "b", "c"})
+
+	if resp.SuccessCount != 2 || resp.FailureCount != 1 {
+		t.Error("Wrong counts : ", resp.SuccessCount, resp.FailureCount)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0] != (TopicManagementError{Index: 1, Token: "b", Reason: "NOT_FOUND"}) {
+		t.Error("Wrong errors : ", resp.Errors)
+	}
+
+	failed := &BatchResponse{Error: "InvalidApiKey", Status: "401 Unauthorized", StatusCode: 401}
+	failed.countResults([]string{"a", "b"})
+	if failed.SuccessCount != 0 || failed.FailureCount != 2 || failed.Errors[1].Reason != "InvalidApiKey" {
+		t.Error("Wrong counts for a failed request : ", failed.FailureCount, failed.Errors)
+	}
+}
+
+func TestInfoTopics(t *testing.T) {
+	result := `{"application":"com.comp.company","platform":"ANDROID","rel":{"topics":{"global":{"addDate":"2016-07-02"},"news":{"addDate":"2017-01-15"}}}}`
+
+	resp, err := parseGetInfo([]byte(result))
+	if err != nil {
+		t.Fatal("Parsing Error: ", err)
+	}
+
+	expected := map[string]string{"global": "2016-07-02", "news": "2017-01-15"}
+	if !reflect.DeepEqual(resp.Topics(), expected) {
+		t.Error("Wrong topics : ", resp.Topics())
+	}
+
+	empty := new(InstanceIdInfoResponse)
+	if len(empty.Topics()) != 0 {
+		t.Error("Expected no topics")
+	}
+}
+
+func TestInvalidRequestUrl(t *testing.T) {
+
+	c := NewFcmClient("key")
+	token := "bad\ntoken"
+
+	if _, err := c.GetInfo(true, token); err == nil {
+		t.Error("GetInfo: expected an error for an invalid url")
+	}
+	if _, err := c.SubscribeToTopic(token, "news"); err == nil {
+		t.Error("SubscribeToTopic: expected an error for an invalid url")
+	}
+	if _, err := c.batchTopicRequest("://invalid", []string{"token0"}, "news"); err == nil {
+		t.Error("batchTopicRequest: expected an error for an invalid url")
+	}
+}
@@ -0,0 +1,37 @@
+package fcm
+
+import (
+	"context"
+)
+
+// SetMaxConcurrentSends bounds the number of requests in flight for this
+// client across all the sends and batch operations, new requests wait for
+// a free slot (or their context). n <= 0 means unbounded, the default.
+// It must be called before the client is used concurrently.
+func (this *FcmClient) SetMaxConcurrentSends(n int) *FcmClient {
+
+	if n <= 0 {
+		this.slots = nil
+	} else {
+		this.slots = make(chan struct{}, n)
+	}
+
+	return this
+}
+
+// acquire waits for a free request slot, the returned func releases it
+func (this *FcmClient) acquire(ctx context.Context) (func(), error) {
+	// the slot is released on the channel it was taken from, even if
+	// SetMaxConcurrentSends replaced it meanwhile
+	slots := this.slots
+	if slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
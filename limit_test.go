@@ -0,0 +1,120 @@
+package fcm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxConcurrentSends(t *testing.T) {
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		fmt.Fprintln(w, `{"results":[]}`)
+	}))
+	defer srv.Close()
+
+	tokens := make([]string, 4*max_batch_tokens)
+
+	c := NewFcmClient("key")
+	c.SetMaxConcurrentSends(2)
+	if _, err := c.batchTopicRequest(srv.URL, tokens, "news"); err != nil {
+		t.Fatal("Batch Error : ", err)
+	}
+
+	if maxInFlight > 2 {
+		t.Error("Too many requests in flight : ", maxInFlight)
+	}
+}
+
+func TestAcquireContext(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetMaxConcurrentSends(1)
+
+	release, err := c.acquire(context.Background())
+	if err != nil {
+		t.Fatal("Acquire Error : ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.acquire(ctx); err != context.DeadlineExceeded {
+		t.Error("Expected the context error, got ", err)
+	}
+
+	release()
+	if _, err := c.acquire(context.Background()); err != nil {
+		t.Error("Slot not released : ", err)
+	}
+}
+
+func TestMaxConcurrentSendsTimeout(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetMaxConcurrentSends(1)
+	c.SetTimeout(50 * time.Millisecond)
+
+	release, err := c.acquire(context.Background())
+	if err != nil {
+		t.Fatal("Acquire Error : ", err)
+	}
+	defer release()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.batchTopicRequest("http://iid.invalid", []string{"token0"}, "news")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Error("Expected a deadline error, got ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Batch request blocked on a full slot")
+	}
+}
+
+func TestReleaseAfterSetMaxConcurrentSends(t *testing.T) {
+
+	c := NewFcmClient("key")
+	c.SetMaxConcurrentSends(1)
+
+	release, err := c.acquire(context.Background())
+	if err != nil {
+		t.Fatal("Acquire Error : ", err)
+	}
+	c.SetMaxConcurrentSends(0)
+
+	done := make(chan struct{})
+	go func() {
+		release()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Release blocked after SetMaxConcurrentSends")
+	}
+}
@@ -0,0 +1,99 @@
+package fcm
+
+import (
+	"regexp"
+)
+
+const (
+	// default_body_log_length max number of bytes of a logged body
+	default_body_log_length = 1024
+)
+
+var (
+	// tokenLikeRegexp matches strings that look like registration tokens
+	tokenLikeRegexp = regexp.MustCompile(`[A-Za-z0-9_\-:]{64,}`)
+)
+
+// Logger is used by the client to report its internal activity (send
+// attempts, responses, errors). keyvals are alternating key/value pairs,
+// which makes *slog.Logger a valid Logger as is.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// nopLogger discards everything, it is the default Logger
+type nopLogger struct{}
+
+// Debug discards the message
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+
+// Error discards the message
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// SetLogger sets the Logger used by the client, nil disables logging
+func (this *FcmClient) SetLogger(l Logger) *FcmClient {
+
+	this.logger = l
+
+	return this
+}
+
+// SetMaxBodyLogLength sets the max number of bytes of the response bodies
+// written to the debug log, longer bodies are truncated. 0 restores the
+// 1KB default and a negative n stops logging bodies.
+func (this *FcmClient) SetMaxBodyLogLength(n int) *FcmClient {
+
+	this.bodyLogLength = n
+
+	return this
+}
+
+// logBody writes body to the debug log, truncated and with the token-like
+// strings redacted, it does nothing without a Logger
+func (this *FcmClient) logBody(msg string, body []byte, keyvals ...interface{}) {
+	max := this.bodyLogLength
+	if max < 0 || this.logger == nil {
+		return
+	}
+	if max == 0 {
+		max = default_body_log_length
+	}
+
+	truncated := len(body) > max
+	if truncated {
+		body = body[:max]
+	}
+
+	keyvals = append(keyvals, "body", redactTokens(string(body)), "truncated", truncated)
+	this.log().Debug(msg, keyvals...)
+}
+
+// redactTokens replaces the token-like strings of s
+func redactTokens(s string) string {
+	return tokenLikeRegexp.ReplaceAllString(s, "[REDACTED]")
+}
+
+// log returns the configured Logger or a no-op one
+func (this *FcmClient) log() Logger {
+	if this.logger == nil {
+		return nopLogger{}
+	}
+
+	return this.logger
+}
+
+// target describes the message target for logging purposes
+func (this *FcmMsg) target() string {
+	if this.To != "" {
+		return this.To
+	}
+	if this.Condition != "" {
+		return this.Condition
+	}
+	if len(this.RegistrationIds) > 0 {
+		return "registration_ids"
+	}
+
+	return ""
+}
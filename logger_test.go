@@ -0,0 +1,66 @@
+package fcm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetLoggerNil(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(topicHandle))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.SetLogger(new(recordLogger))
+	c.SetLogger(nil)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	if _, err := c.Send(); err != nil {
+		t.Error("Response Error : ", err)
+	}
+}
+
+// recordLogger keeps the key-value pairs of the debug messages
+type recordLogger struct {
+	debug []map[string]interface{}
+}
+
+func (this *recordLogger) Debug(msg string, keyvals ...interface{}) {
+	entry := map[string]interface{}{"msg": msg}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		entry[keyvals[i].(string)] = keyvals[i+1]
+	}
+	this.debug = append(this.debug, entry)
+}
+
+func (this *recordLogger) Error(msg string, keyvals ...interface{}) {}
+
+func TestLogBody(t *testing.T) {
+
+	l := new(recordLogger)
+	c := NewFcmClient("key")
+	c.SetLogger(l)
+
+	token := strings.Repeat("a", 100)
+	c.logBody("body", []byte(`{"registration_id":"`+token+`"}`))
+
+	body := l.debug[0]["body"].(string)
+	if strings.Contains(body, token) || !strings.Contains(body, "[REDACTED]") {
+		t.Error("Token not redacted : ", body)
+	}
+
+	c.SetMaxBodyLogLength(10)
+	c.logBody("body", []byte(strings.Repeat("b", 20)))
+	if l.debug[1]["body"] != strings.Repeat("b", 10) || l.debug[1]["truncated"] != true {
+		t.Error("Body not truncated : ", l.debug[1])
+	}
+
+	c.SetMaxBodyLogLength(-1)
+	c.logBody("body", []byte("{}"))
+	if len(l.debug) != 2 {
+		t.Error("Body logged while disabled")
+	}
+}
@@ -0,0 +1,62 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// serialized_version version of the SerializedRequest format
+	serialized_version = 1
+	// legacy_api name of the legacy HTTP protocol in a SerializedRequest
+	legacy_api = "legacy"
+)
+
+// SerializedRequest self-describing form of an outgoing request, as
+// returned by MarshalRequest. Message holds the exact bytes sent to fcm.
+type SerializedRequest struct {
+	Version int             `json:"version"`
+	Api     string          `json:"api"`
+	Target  string          `json:"target,omitempty"`
+	Message json.RawMessage `json:"message"`
+}
+
+// MarshalRequest serializes the request the client would send for the
+// current message, so it can be persisted and replayed by SendSerialized
+func (this *FcmClient) MarshalRequest() ([]byte, error) {
+
+	msg := this.truncateBody(&this.Message)
+
+	jsonByte, err := msg.toJsonByte()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&SerializedRequest{
+		Version: serialized_version,
+		Api:     legacy_api,
+		Target:  msg.target(),
+		Message: jsonByte,
+	})
+}
+
+// SendSerialized sends a request returned by MarshalRequest, byte for byte,
+// the current message of the client is not used
+func (this *FcmClient) SendSerialized(ctx context.Context, data []byte) (*FcmResponseStatus, error) {
+
+	req := new(SerializedRequest)
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	if req.Version != serialized_version || req.Api != legacy_api {
+		return nil, fmt.Errorf("fcm: unsupported serialized request (version %d, api %q)", req.Version, req.Api)
+	}
+
+	msg := new(FcmMsg)
+	if err := json.Unmarshal(req.Message, msg); err != nil {
+		return nil, err
+	}
+
+	return this.sendEncoded(ctx, msg, req.Message, nil)
+}
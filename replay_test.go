@@ -0,0 +1,60 @@
+package fcm
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMarshalRequestReplay(t *testing.T) {
+
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, body)
+		regIdHandle(w, r)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmRegIdsMsg([]string{"token0", "token1", "token2"}, map[string]interface{}{"msg": "Hello World", "n": 1})
+	c.SetPriority(Priority_HIGH)
+
+	data, err := c.MarshalRequest()
+	if err != nil {
+		t.Fatal("MarshalRequest Error : ", err)
+	}
+	if _, err := c.Send(); err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	replayer := NewFcmClient("key")
+	replayer.SetEndpoint(srv.URL)
+	res, err := replayer.SendSerialized(context.Background(), data)
+	if err != nil {
+		t.Fatal("SendSerialized Error : ", err)
+	}
+
+	if !bytes.Equal(bodies[0], bodies[1]) {
+		t.Error("Replayed request differs : ", string(bodies[0]), string(bodies[1]))
+	}
+	if res.TokenResults[2].Token != "token2" {
+		t.Error("Replayed results not matched with tokens : ", res.TokenResults)
+	}
+}
+
+func TestSendSerializedInvalid(t *testing.T) {
+
+	c := NewFcmClient("key")
+
+	if _, err := c.SendSerialized(context.Background(), []byte(`{"version":2,"api":"legacy","message":{}}`)); err == nil {
+		t.Error("Expected an error for an unsupported version")
+	}
+	if _, err := c.SendSerialized(context.Background(), []byte(`not json`)); err == nil {
+		t.Error("Expected an error for malformed data")
+	}
+}
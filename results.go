@@ -0,0 +1,65 @@
+package fcm
+
+import (
+	"net/http"
+)
+
+// TokenResult typed result of a send for a single registration token
+type TokenResult struct {
+	Token       string `json:"token"`
+	MessageID   string `json:"message_id,omitempty"`
+	Error       string `json:"error,omitempty"`
+	CanonicalID string `json:"canonical_id,omitempty"`
+	Retryable   bool   `json:"retryable"`
+}
+
+// newTokenResults builds the typed results of a response to msg, results
+// are matched with the registration ids (or the single "to" token) by index
+func newTokenResults(msg *FcmMsg, results []map[string]string) []TokenResult {
+	if len(results) == 0 {
+		return nil
+	}
+
+	tokens := msg.RegistrationIds
+	if len(tokens) == 0 && msg.To != "" {
+		tokens = []string{msg.To}
+	}
+
+	typed := make([]TokenResult, len(results))
+	for i, result := range results {
+		if i < len(tokens) {
+			typed[i].Token = tokens[i]
+		}
+		typed[i].MessageID = result["message_id"]
+		typed[i].Error = result[error_key]
+		typed[i].CanonicalID = result["registration_id"]
+		typed[i].Retryable = retreyableErrors[typed[i].Error]
+	}
+
+	return typed
+}
+
+// Err returns the error of the token as a *FcmError, which matches the
+// sentinel errors (e.g. errors.Is(err, ErrUnregistered)), or nil
+func (this TokenResult) Err() error {
+	if this.Error == "" {
+		return nil
+	}
+
+	return &FcmError{Code: http.StatusOK, Status: http.StatusText(http.StatusOK), ErrorCode: this.Error}
+}
+
+// InvalidTokens returns the tokens fcm will never deliver to, which should
+// be removed from storage. Permanent errors are InvalidRegistration and
+// NotRegistered; MissingRegistration (no token was sent), retryable errors
+// (Unavailable, InternalServerError) and other errors are not reported.
+func (this *FcmResponseStatus) InvalidTokens() []string {
+	tokens := []string{}
+	for _, result := range this.TokenResults {
+		if result.Token != "" && invalidTokenErrors[result.Error] {
+			tokens = append(tokens, result.Token)
+		}
+	}
+
+	return tokens
+}
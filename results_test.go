@@ -0,0 +1,75 @@
+package fcm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestTokenResults(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(regIdHandle))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmRegIdsMsg([]string{"token0", "token1", "token2"}, map[string]string{"msg": "Hello World"})
+
+	res, err := c.Send()
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if len(res.TokenResults) != 3 {
+		t.Fatal("Expected 3 token results, got ", len(res.TokenResults))
+	}
+	if res.TokenResults[0].Token != "token0" || res.TokenResults[0].MessageID != "0:1448128667408487%ecaaa23db3fd7efd" {
+		t.Error("Wrong first result : ", res.TokenResults[0])
+	}
+	if res.TokenResults[2].Token != "token2" || res.TokenResults[2].Error != "InvalidRegistration" || res.TokenResults[2].Retryable {
+		t.Error("Wrong failed result : ", res.TokenResults[2])
+	}
+}
+
+func TestNewTokenResults(t *testing.T) {
+
+	msg := &FcmMsg{To: "token0"}
+	results := []map[string]string{{"message_id": "1", "registration_id": "token9"}}
+
+	typed := newTokenResults(msg, results)
+	if len(typed) != 1 || typed[0].Token != "token0" || typed[0].CanonicalID != "token9" {
+		t.Error("Wrong single token result : ", typed)
+	}
+
+	typed = newTokenResults(&FcmMsg{RegistrationIds: []string{"a"}}, []map[string]string{{"error": "Unavailable"}})
+	if !typed[0].Retryable {
+		t.Error("Unavailable must be retryable")
+	}
+
+	if newTokenResults(msg, nil) != nil {
+		t.Error("Expected no token results for a topic response")
+	}
+}
+
+func TestInvalidTokens(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"success":1,"failure":4,"results":[{"message_id":"1"},{"error":"NotRegistered"},{"error":"Unavailable"},{"error":"InvalidRegistration"},{"error":"MissingRegistration"}]}`)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmRegIdsMsg([]string{"token0", "token1", "token2", "token3", "token4"}, map[string]string{"msg": "Hello World"})
+
+	res, err := c.Send()
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if !reflect.DeepEqual(res.InvalidTokens(), []string{"token1", "token3"}) {
+		t.Error("Wrong invalid tokens : ", res.InvalidTokens())
+	}
+}
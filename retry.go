@@ -0,0 +1,179 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/url"
+	"time"
+)
+
+const (
+	// default_retry_base_delay first backoff delay of SendWithRetry, doubled at each retry
+	default_retry_base_delay = time.Second
+	// default_retry_max_delay max backoff delay of SendWithRetry
+	default_retry_max_delay = 30 * time.Second
+)
+
+// SendWithRetry sends to fcm and retries up to maxRetries times while the
+// response is retryable (see ShouldRetry) or the request failed at the
+// network level. It waits for the Retry-After delay when fcm gives one,
+// otherwise for an exponential backoff with jitter (1s doubling, capped at
+// 30s). When only some tokens of a multicast failed with a retryable
+// error, only those tokens are sent again and their results are merged
+// back in place. The response (merged for multicasts) and the error of the
+// last attempt are returned.
+func (this *FcmClient) SendWithRetry(maxRetries int) (*FcmResponseStatus, error) {
+	return this.SendWithRetryContext(context.Background(), maxRetries)
+}
+
+// SendWithRetryContext works like SendWithRetry, ctx bounds the sends and
+// the waits between them
+func (this *FcmClient) SendWithRetryContext(ctx context.Context, maxRetries int) (*FcmResponseStatus, error) {
+	return this.sendWithRetry(ctx, &this.Message, maxRetries)
+}
+
+// sendWithRetry sends base and retries it like SendWithRetry, base is left
+// untouched
+func (this *FcmClient) sendWithRetry(ctx context.Context, base *FcmMsg, maxRetries int) (*FcmResponseStatus, error) {
+
+	start := time.Now()
+	msg := base
+
+	// status the merged response, pending the indexes of the registration
+	// ids sent again, nil when the whole message is sent
+	var status *FcmResponseStatus
+	var pending []int
+	attempts := 0
+
+	for retry := 0; ; retry++ {
+		resp, err := this.sendOnce(ctx, msg)
+		attempts++
+
+		if pending == nil {
+			status = resp
+		} else if err == nil && resp.Ok {
+			mergeRetried(status, resp, pending)
+		}
+
+		// failed the request got no fcm response
+		failed := err != nil && !errors.Is(err, ErrNon200)
+
+		retryable := false
+		switch {
+		case failed:
+			retryable = isNetworkError(err) && ctx.Err() == nil
+		case !resp.Ok:
+			retryable = resp.ShouldRetry()
+		case len(base.RegistrationIds) > 0:
+			pending = retryableIndexes(status)
+			retryable = len(pending) > 0
+			if retryable {
+				msg = withRegistrationIds(base, pending)
+			}
+		default:
+			retryable = resp.ShouldRetry()
+		}
+
+		if !retryable || retry >= maxRetries {
+			status.Attempts = attempts
+			status.Latency = time.Since(start)
+			return status, err
+		}
+
+		delay := this.backoffDelay(retry)
+		if !failed {
+			if after, perr := resp.GetRetryAfterTime(); perr == nil && after > 0 {
+				delay = after
+			}
+		}
+
+		this.log().Debug("fcm: retrying send", "target", msg.target(), "retry", retry+1, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			status.Attempts = attempts
+			status.Latency = time.Since(start)
+			return status, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// isNetworkError reports whether err comes from the http round trip
+func isNetworkError(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// backoffDelay returns the exponential backoff delay before the given retry,
+// with up to 50% of random jitter
+func (this *FcmClient) backoffDelay(retry int) time.Duration {
+	base, max := this.retryBaseDelay, this.retryMaxDelay
+	if base <= 0 {
+		base = default_retry_base_delay
+	}
+	if max <= 0 {
+		max = default_retry_max_delay
+	}
+
+	delay := max
+	if retry < 30 && base<<uint(retry) < max {
+		delay = base << uint(retry)
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryableIndexes returns the indexes of the results with a retryable error
+func retryableIndexes(status *FcmResponseStatus) []int {
+	indexes := []int{}
+	for i, result := range status.Results {
+		if retreyableErrors[result[error_key]] {
+			indexes = append(indexes, i)
+		}
+	}
+
+	return indexes
+}
+
+// withRegistrationIds returns a copy of msg sent only to the registration
+// ids at the given indexes
+func withRegistrationIds(msg *FcmMsg, indexes []int) *FcmMsg {
+	retry := *msg
+	retry.RegistrationIds = make([]string, len(indexes))
+	for i, index := range indexes {
+		retry.RegistrationIds[i] = msg.RegistrationIds[index]
+	}
+
+	return &retry
+}
+
+// mergeRetried puts the results of a retry sent to the tokens at indexes
+// back in place in status, and updates the counts
+func mergeRetried(status *FcmResponseStatus, retry *FcmResponseStatus, indexes []int) {
+	for i, index := range indexes {
+		if i >= len(retry.Results) || index >= len(status.Results) {
+			break
+		}
+
+		result := retry.Results[i]
+		if _, failed := result[error_key]; !failed {
+			status.Success++
+			status.Fail--
+		}
+		if _, canonical := result["registration_id"]; canonical {
+			status.Canonical_ids++
+		}
+
+		status.Results[index] = result
+		if index < len(status.TokenResults) && i < len(retry.TokenResults) {
+			status.TokenResults[index] = retry.TokenResults[i]
+		}
+	}
+
+	status.RetryAfter = retry.RetryAfter
+	status.Headers = retry.Headers
+}
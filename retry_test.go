@@ -0,0 +1,152 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fastRetries shortens the backoff of c
+func fastRetries(c *FcmClient) {
+	c.retryBaseDelay, c.retryMaxDelay = time.Millisecond, 4*time.Millisecond
+}
+
+func TestSendWithRetryServerError(t *testing.T) {
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		topicHandle(w, r)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	fastRetries(c)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	res, err := c.SendWithRetry(5)
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+	if !res.Ok || res.Attempts != 3 || requests != 3 {
+		t.Error("Wrong retried response : ", res.Ok, res.Attempts, requests)
+	}
+}
+
+func TestSendWithRetryGivesUp(t *testing.T) {
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	fastRetries(c)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	res, _ := c.SendWithRetry(2)
+	if res.StatusCode != 500 || res.Attempts != 3 || requests != 3 {
+		t.Error("Wrong retries : ", res.StatusCode, res.Attempts, requests)
+	}
+}
+
+func TestSendWithRetryNotRetryable(t *testing.T) {
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	fastRetries(c)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	c.SendWithRetry(3)
+	if requests != 1 {
+		t.Error("Non retryable response retried : ", requests)
+	}
+}
+
+func TestSendWithRetryOnlyFailedTokens(t *testing.T) {
+
+	var sent [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+		sent = append(sent, msg.RegistrationIds)
+
+		if len(sent) == 1 {
+			w.Header().Set(retry_after_header, "1ms")
+			fmt.Fprintln(w, `{"success":1,"failure":2,"results":[{"message_id":"1"},{"error":"Unavailable"},{"error":"NotRegistered"}]}`)
+			return
+		}
+		fmt.Fprintln(w, `{"success":1,"failure":0,"results":[{"message_id":"2"}]}`)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	fastRetries(c)
+	c.NewFcmRegIdsMsg([]string{"token0", "token1", "token2"}, map[string]string{"msg": "Hello World"})
+
+	res, err := c.SendWithRetry(3)
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if len(sent) != 2 || len(sent[1]) != 1 || sent[1][0] != "token1" {
+		t.Fatal("Only the retryable token must be sent again : ", sent)
+	}
+	if res.Success != 2 || res.Fail != 1 || res.Attempts != 2 {
+		t.Error("Wrong merged counts : ", res.Success, res.Fail, res.Attempts)
+	}
+	if res.Results[1]["message_id"] != "2" || res.TokenResults[1].Token != "token1" || res.Results[2][error_key] != "NotRegistered" {
+		t.Error("Wrong merged results : ", res.Results)
+	}
+}
+
+func TestSendWithRetryContext(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.retryBaseDelay = time.Hour
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.SendWithRetryContext(ctx, 3); err != context.DeadlineExceeded {
+		t.Error("Expected the deadline error, got ", err)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+
+	c := NewFcmClient("key")
+	for retry := 0; retry < 40; retry++ {
+		delay := c.backoffDelay(retry)
+		if delay <= 0 || delay > default_retry_max_delay {
+			t.Error("Delay out of range : ", retry, delay)
+		}
+	}
+}
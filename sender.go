@@ -0,0 +1,115 @@
+package fcm
+
+import (
+	"context"
+	"time"
+)
+
+// Sender sends messages given per call, it holds only the configuration
+// and is safe for concurrent use, unlike the fluent FcmClient which holds
+// the message being built. Create it once with NewSender and share it.
+type Sender struct {
+	client *FcmClient
+}
+
+// SenderOption configures a Sender
+type SenderOption func(*FcmClient) error
+
+// WithLogger sets the Logger of the Sender, it must be safe for concurrent use
+func WithLogger(l Logger) SenderOption {
+	return func(c *FcmClient) error {
+		c.SetLogger(l)
+		return nil
+	}
+}
+
+// WithProxy routes the requests through the given proxy url
+func WithProxy(proxyUrl string) SenderOption {
+	return func(c *FcmClient) error {
+		return c.SetProxy(proxyUrl)
+	}
+}
+
+// WithUserAgent overrides the default user agent
+func WithUserAgent(ua string) SenderOption {
+	return func(c *FcmClient) error {
+		c.SetUserAgent(ua)
+		return nil
+	}
+}
+
+// WithEndpoint overrides the fcm server base url, see SetEndpoint
+func WithEndpoint(base string) SenderOption {
+	return func(c *FcmClient) error {
+		c.SetEndpoint(base)
+		return nil
+	}
+}
+
+// WithTimeout sets the time budget of a send, see SetTimeout
+func WithTimeout(d time.Duration) SenderOption {
+	return func(c *FcmClient) error {
+		c.SetTimeout(d)
+		return nil
+	}
+}
+
+// WithCircuitBreaker enables the circuit breaker, see SetCircuitBreaker
+func WithCircuitBreaker(threshold int, window time.Duration, cooldown time.Duration) SenderOption {
+	return func(c *FcmClient) error {
+		c.SetCircuitBreaker(threshold, window, cooldown)
+		return nil
+	}
+}
+
+// WithMaxConcurrentSends bounds the requests in flight, see SetMaxConcurrentSends
+func WithMaxConcurrentSends(n int) SenderOption {
+	return func(c *FcmClient) error {
+		c.SetMaxConcurrentSends(n)
+		return nil
+	}
+}
+
+// WithMaxBodyLength limits the notification bodies, see SetMaxBodyLength
+func WithMaxBodyLength(n int) SenderOption {
+	return func(c *FcmClient) error {
+		c.SetMaxBodyLength(n)
+		return nil
+	}
+}
+
+// NewSender creates a Sender authenticated by apiKey
+func NewSender(apiKey string, opts ...SenderOption) (*Sender, error) {
+
+	c := NewFcmClient(apiKey)
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Sender{client: c}, nil
+}
+
+// Send sends msg as is
+func (this *Sender) Send(ctx context.Context, msg *FcmMsg) (*FcmResponseStatus, error) {
+	return this.client.sendOnce(ctx, msg)
+}
+
+// SendWithRetry sends msg and retries it up to maxRetries times, see
+// FcmClient.SendWithRetry, msg is left untouched
+func (this *Sender) SendWithRetry(ctx context.Context, msg *FcmMsg, maxRetries int) (*FcmResponseStatus, error) {
+	return this.client.sendWithRetry(ctx, msg, maxRetries)
+}
+
+// SendMulticast sends msg to tokens, in chunks when there are more than
+// max_registration_ids of them, the target of msg is ignored
+func (this *Sender) SendMulticast(ctx context.Context, msg *FcmMsg, tokens []string) (*FcmResponseStatus, error) {
+
+	multicast := *msg
+	multicast.To = ""
+	multicast.Condition = ""
+	multicast.RegistrationIds = tokens
+
+	return this.client.sendChunks(ctx, &multicast, nil)
+}
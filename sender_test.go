@@ -0,0 +1,118 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestSenderConcurrentSends(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+		fmt.Fprintf(w, `{"results":[{"message_id":%q}]}`, msg.To)
+	}))
+	defer srv.Close()
+
+	s, err := NewSender("key", WithEndpoint(srv.URL), WithUserAgent("sender-test"), WithMaxConcurrentSends(4))
+	if err != nil {
+		t.Fatal("NewSender Error : ", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			token := fmt.Sprintf("token%d", i)
+			res, err := s.Send(context.Background(), &FcmMsg{To: token, Data: map[string]string{"i": token}})
+			if err != nil {
+				t.Error("Response Error : ", err)
+				return
+			}
+			if res.Results[0]["message_id"] != token {
+				t.Error("Response mixed up between sends : ", res.Results)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSenderSendMulticast(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(regIdHandle))
+	defer srv.Close()
+
+	s, err := NewSender("key", WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal("NewSender Error : ", err)
+	}
+
+	msg := &FcmMsg{To: "/topics/ignored", Data: map[string]string{"msg": "Hello World"}}
+	res, err := s.SendMulticast(context.Background(), msg, []string{"token0", "token1", "token2"})
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if res.Success != 2 || res.TokenResults[2].Token != "token2" {
+		t.Error("Wrong multicast response : ", res.Success, res.TokenResults)
+	}
+	if msg.To != "/topics/ignored" || msg.RegistrationIds != nil {
+		t.Error("SendMulticast changed the message")
+	}
+}
+
+func TestNewSenderInvalidOption(t *testing.T) {
+
+	if _, err := NewSender("key", WithProxy("not a url")); err == nil {
+		t.Error("Expected the option error")
+	}
+}
+
+func TestSenderSendWithRetry(t *testing.T) {
+
+	var sent [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+		sent = append(sent, msg.RegistrationIds)
+
+		switch len(sent) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			fmt.Fprintln(w, `{"success":1,"failure":1,"results":[{"message_id":"1"},{"error":"Unavailable"}]}`)
+		default:
+			fmt.Fprintln(w, `{"success":1,"failure":0,"results":[{"message_id":"2"}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := NewSender("key", WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal("NewSender Error : ", err)
+	}
+	fastRetries(s.client)
+
+	msg := &FcmMsg{RegistrationIds: []string{"token0", "token1"}, Data: map[string]string{"msg": "Hello World"}}
+	res, err := s.SendWithRetry(context.Background(), msg, 3)
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if len(sent) != 3 || len(sent[2]) != 1 || sent[2][0] != "token1" {
+		t.Error("Wrong retried tokens : ", sent)
+	}
+	if res.Success != 2 || res.Fail != 0 || res.Attempts != 3 {
+		t.Error("Wrong merged counts : ", res.Success, res.Fail, res.Attempts)
+	}
+	if len(msg.RegistrationIds) != 2 {
+		t.Error("SendWithRetry changed the message : ", msg.RegistrationIds)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build go1.21
+
+package fcm
+
+import (
+	"log/slog"
+)
+
+// SetSlogLogger routes the client logs to a structured slog.Logger,
+// nil disables logging
+func (this *FcmClient) SetSlogLogger(l *slog.Logger) *FcmClient {
+
+	if l == nil {
+		this.logger = nil
+	} else {
+		this.logger = l
+	}
+
+	return this
+}
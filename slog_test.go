@@ -0,0 +1,53 @@
+//go:build go1.21
+
+package fcm
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(topicHandle))
+	defer srv.Close()
+
+	buf := new(bytes.Buffer)
+	l := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.SetSlogLogger(l)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	if _, err := c.Send(); err != nil {
+		t.Error("Response Error : ", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, `"target":"/topics/topicName"`) {
+		t.Error("Missing target attribute: ", out)
+	}
+	if !strings.Contains(out, `"status":200`) {
+		t.Error("Missing status attribute: ", out)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(topicHandle))
+	defer srv.Close()
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.SetSlogLogger(nil)
+	c.NewFcmMsgTo("/topics/topicName", map[string]string{"msg": "Hello World"})
+
+	if _, err := c.Send(); err != nil {
+		t.Error("Response Error : ", err)
+	}
+}
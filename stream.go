@@ -0,0 +1,105 @@
+package fcm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// SetResultHandler makes Send, SendWithContext, SendDryRun and SendInChunks
+// stream-decode the response: handler is called with each entry of the results array, in
+// order, as soon as it is decoded, and Results, TokenResults and RawBody are
+// left empty, so InvalidTokens and Error do not see per-token errors. This
+// keeps memory flat for large multicast responses. The other sends (e.g.
+// SendWithRetry, SendMessage, ValidateToken) never stream. nil restores the
+// default.
+func (this *FcmClient) SetResultHandler(handler func(i int, result map[string]string)) *FcmClient {
+
+	this.resultHandler = handler
+
+	return this
+}
+
+// decodeStatusStream decodes a FCM response body from r, passing each entry
+// of the results array to handler instead of storing it
+func (this *FcmResponseStatus) decodeStatusStream(r io.Reader, handler func(i int, result map[string]string)) error {
+
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		var field interface{}
+		switch tok {
+		case "multicast_id":
+			field = &this.MulticastId
+		case "success":
+			field = &this.Success
+		case "failure":
+			field = &this.Fail
+		case "canonical_ids":
+			field = &this.Canonical_ids
+		case "message_id":
+			field = &this.MsgId
+		case error_key:
+			field = &this.Err
+		case "results":
+			counted := func(i int, result map[string]string) {
+				if retreyableErrors[result[error_key]] {
+					this.streamedRetryable++
+				}
+				handler(i, result)
+			}
+			if err := decodeResultsStream(dec, counted); err != nil {
+				return err
+			}
+			continue
+		default:
+			field = new(json.RawMessage)
+		}
+
+		if err := dec.Decode(field); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+// decodeResultsStream decodes the results array one entry at a time
+func decodeResultsStream(dec *json.Decoder, handler func(i int, result map[string]string)) error {
+
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+
+	for i := 0; dec.More(); i++ {
+		result := make(map[string]string)
+		if err := dec.Decode(&result); err != nil {
+			return err
+		}
+		handler(i, result)
+	}
+
+	return expectDelim(dec, ']')
+}
+
+// expectDelim reads the next token and checks it is the delim d
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != d {
+		return fmt.Errorf("fcm: unexpected token %v in response, expecting %v", tok, d)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package fcm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResultHandler(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(regIdHandle))
+	defer srv.Close()
+
+	var results []map[string]string
+	indexes := []int{}
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmRegIdsMsg([]string{"token0", "token1", "token2"}, map[string]string{"msg": "Hello World"})
+	c.SetResultHandler(func(i int, result map[string]string) {
+		indexes = append(indexes, i)
+		results = append(results, result)
+	})
+
+	res, err := c.Send()
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if !res.Ok || res.Success != 2 || res.Fail != 1 || res.MulticastId != 1003859738309903334 {
+		t.Error("Wrong response status : ", res)
+	}
+	if len(res.Results) != 0 {
+		t.Error("Results must not be stored when streaming")
+	}
+	if len(results) != 3 || indexes[2] != 2 || results[2][error_key] != "InvalidRegistration" {
+		t.Error("Wrong streamed results : ", indexes, results)
+	}
+}
+
+func TestDecodeStatusStreamInvalid(t *testing.T) {
+
+	status := new(FcmResponseStatus)
+	err := status.decodeStatusStream(strings.NewReader(`{"results":{}}`), func(int, map[string]string) {})
+	if err == nil {
+		t.Error("Expected an error for a malformed results array")
+	}
+}
+
+func TestResultHandlerInternalSends(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"success":0,"failure":1,"results":[{"error":"NotRegistered"}]}`)
+	}))
+	defer srv.Close()
+
+	streamed := 0
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.SetResultHandler(func(int, map[string]string) { streamed++ })
+
+	ok, err := c.ValidateToken(context.Background(), "stale")
+	if ok || err != nil {
+		t.Error("Unregistered token reported valid : ", ok, err)
+	}
+	if streamed != 0 {
+		t.Error("ValidateToken used the result handler")
+	}
+}
+
+func TestResultHandlerRetryable(t *testing.T) {
+
+	status := new(FcmResponseStatus)
+	status.StatusCode = 200
+	err := status.decodeStatusStream(strings.NewReader(`{"failure":1,"results":[{"message_id":"1"},{"error":"Unavailable"}]}`), func(int, map[string]string) {})
+	if err != nil {
+		t.Fatal("Decode Error : ", err)
+	}
+
+	if !status.IsTimeout() || !status.ShouldRetry() {
+		t.Error("Streamed retryable result not counted")
+	}
+}
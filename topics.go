@@ -0,0 +1,45 @@
+package fcm
+
+import (
+	"context"
+)
+
+const (
+	// max_topic_sends max number of concurrent requests of SendToTopics
+	max_topic_sends = 10
+)
+
+// MulticastResult results of a message fanned out to several targets (or
+// of several messages), Responses and Errors are aligned with Targets
+type MulticastResult struct {
+	Targets   []string
+	Responses []*FcmResponseStatus
+	Errors    []error
+	Success   int
+	Fail      int
+}
+
+// SendToTopics sends the message to each topic with one request per topic,
+// at most max_topic_sends at a time. It is meant for more topics than a
+// condition allows. The message target is ignored and left untouched.
+func (this *FcmClient) SendToTopics(ctx context.Context, topics []string) (*MulticastResult, error) {
+
+	msgs := make([]*FcmMsg, len(topics))
+	for i, topic := range topics {
+		msg := this.Message
+		msg.To = topicTarget(topic)
+		msg.RegistrationIds = nil
+		msg.Condition = ""
+		msgs[i] = &msg
+	}
+
+	result := this.sendAll(ctx, msgs, max_topic_sends)
+	copy(result.Targets, topics)
+
+	return result, ctx.Err()
+}
+
+// topicTarget returns the legacy "to" value of a topic name
+func topicTarget(topic string) string {
+	return topics + extractTopicName(topic)
+}
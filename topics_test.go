@@ -0,0 +1,46 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendToTopics(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(FcmMsg)
+		json.NewDecoder(r.Body).Decode(msg)
+
+		if msg.To == "/topics/broken" {
+			fmt.Fprintln(w, `{"error":"TopicsMessageRateExceeded"}`)
+			return
+		}
+		topicHandle(w, r)
+	}))
+	defer srv.Close()
+
+	topics := []string{"a", "/topics/b", "broken", "c", "d", "e", "f"}
+
+	c := NewFcmClient("key")
+	c.SetEndpoint(srv.URL)
+	c.NewFcmMsgTo("token0", map[string]string{"msg": "Hello World"})
+
+	res, err := c.SendToTopics(context.Background(), topics)
+	if err != nil {
+		t.Fatal("Response Error : ", err)
+	}
+
+	if res.Success != 6 || res.Fail != 1 {
+		t.Error("Wrong counts : ", res.Success, res.Fail)
+	}
+	if res.Targets[2] != "broken" || res.Responses[2].Err != "TopicsMessageRateExceeded" {
+		t.Error("Results not aligned with topics : ", res.Responses[2])
+	}
+	if c.Message.To != "token0" {
+		t.Error("SendToTopics changed the message")
+	}
+}
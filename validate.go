@@ -0,0 +1,95 @@
+package fcm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// reservedDataKeys data keys reserved by fcm
+	reservedDataKeys = map[string]bool{
+		"from":         true,
+		"notification": true,
+		"message_type": true,
+	}
+
+	// reservedDataPrefixes data key prefixes reserved by fcm
+	reservedDataPrefixes = []string{"google", "gcm"}
+)
+
+// Validate checks the message for mistakes fcm would reject, without
+// sending it: a missing or ambiguous target, a condition over the topics
+// limit, too many registration ids for a single request, a negative time
+// to live, an unknown priority or reserved data keys.
+func (this *FcmClient) Validate() error {
+	return this.Message.validate()
+}
+
+// validate checks msg, see Validate
+func (this *FcmMsg) validate() error {
+
+	targets := 0
+	for _, set := range []bool{this.To != "", len(this.RegistrationIds) > 0, this.Condition != ""} {
+		if set {
+			targets++
+		}
+	}
+	if targets == 0 {
+		return errors.New("fcm: no target set, use a token/topic, registration ids or a condition")
+	}
+	if targets > 1 {
+		return errors.New("fcm: only one of to, registration ids and condition can be set")
+	}
+
+	if this.Condition != "" {
+		if err := ValidateCondition(this.Condition); err != nil {
+			return err
+		}
+	}
+
+	if len(this.RegistrationIds) > max_registration_ids {
+		return fmt.Errorf("fcm: %d registration ids, the limit is %d per request, use SendInChunks", len(this.RegistrationIds), max_registration_ids)
+	}
+
+	if this.TimeToLive < 0 {
+		return fmt.Errorf("fcm: negative time to live %d", this.TimeToLive)
+	}
+
+	if this.Priority != "" && this.Priority != Priority_HIGH && this.Priority != Priority_NORMAL {
+		return fmt.Errorf("fcm: invalid priority %q, use %q or %q", this.Priority, Priority_HIGH, Priority_NORMAL)
+	}
+
+	return validateDataKeys(this.Data)
+}
+
+// validateDataKeys checks that data is a json object without reserved keys
+func validateDataKeys(data interface{}) error {
+	if data == nil {
+		return nil
+	}
+
+	jsonByte, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(jsonByte, &fields); err != nil {
+		return fmt.Errorf("fcm: data payload must be a json object: %v", err)
+	}
+
+	for key := range fields {
+		if reservedDataKeys[key] {
+			return fmt.Errorf("fcm: data key %q is reserved", key)
+		}
+		for _, prefix := range reservedDataPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				return fmt.Errorf("fcm: data key %q is reserved, keys must not start with %q", key, prefix)
+			}
+		}
+	}
+
+	return nil
+}
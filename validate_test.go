@@ -0,0 +1,36 @@
+package fcm
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+
+	valid := NewFcmClient("key")
+	valid.NewFcmMsgTo("/topics/news", map[string]string{"msg": "Hello World"})
+	valid.SetPriority(Priority_HIGH)
+	if err := valid.Validate(); err != nil {
+		t.Error("Valid message rejected : ", err)
+	}
+
+	cases := map[string]FcmMsg{
+		"no target":        {Data: map[string]string{"msg": "Hello"}},
+		"two targets":      {To: "token0", RegistrationIds: []string{"token1"}},
+		"bad condition":    {Condition: "TopicA"},
+		"too many tokens":  {RegistrationIds: make([]string, max_registration_ids+1)},
+		"negative ttl":     {To: "token0", TimeToLive: -1},
+		"bad priority":     {To: "token0", Priority: "urgent"},
+		"reserved key":     {To: "token0", Data: map[string]string{"from": "me"}},
+		"reserved prefix":  {To: "token0", Data: map[string]interface{}{"google.sent_time": 1}},
+		"non object data":  {To: "token0", Data: []string{"a"}},
+		"bad data payload": {To: "token0", Data: make(chan int)},
+	}
+
+	for name, msg := range cases {
+		c := NewFcmClient("key")
+		c.Message = msg
+		if err := c.Validate(); err == nil {
+			t.Error("Invalid message accepted : ", name)
+		}
+	}
+}